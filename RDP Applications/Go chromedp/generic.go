@@ -4,12 +4,26 @@ package main
 import (
 	// Standard library packages
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	// Driver to talk to Chrome-based browsers leveraging the
 	// Chrome DevTools protocol
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 )
 
@@ -29,6 +43,8 @@ func main() {
 
 	loginUrl := flag.String("url", "", "login URL")
 	ignoreCertificateErrors := flag.Bool("insecure", false, "skip certificate validation")
+	caFile := flag.String("ca-file", "", "PEM file of the CA certificates trusted besides the system's ones")
+	spkiPins := flag.String("spki-pins", "", "comma separated base64 SHA-256 hashes of trusted public keys")
 
 	debug := flag.Bool("debug", false, "enable debug logging")
 
@@ -76,6 +92,19 @@ func main() {
 			chromedp.Flag("ignore-certificate-errors", true),
 		)
 	}
+	var trust trustedKeys
+	if !*ignoreCertificateErrors && (*caFile != "" || *spkiPins != "") {
+		// Certificate errors are only ignored if the chain contains one of the trusted keys, see trustOnlyOn
+		var err error
+		if trust, err = loadTrustedKeys(*caFile, *spkiPins); err != nil {
+			// Invalid parameter, exit code 2 like the flag package
+			log.Println("Cannot load trusted certificates: " + err.Error())
+			os.Exit(2)
+		}
+		opts = append(opts,
+			chromedp.Flag("ignore-certificate-errors-spki-list", trust.browserFlag()),
+		)
+	}
 
 	browserOpts := make([]chromedp.ContextOption, 0)
 	if *debug {
@@ -89,7 +118,11 @@ func main() {
 	runCtx, _ := chromedp.NewContext(allocCtx, chromedp.WithLogf(log.Printf))
 
 	// Running login actions
-	err := chromedp.Run(runCtx,
+	var actions []chromedp.Action
+	if trust != nil {
+		actions = append(actions, trust.trustOnlyOn(*loginUrl))
+	}
+	err := chromedp.Run(runCtx, append(actions,
 		chromedp.Navigate(*loginUrl),
 		chromedp.Sleep(time.Millisecond*time.Duration(*browserInputDelay)),
 		chromedp.SendKeys(*accountSelector, *account, chromedp.ByQuery, chromedp.NodeVisible),
@@ -97,7 +130,7 @@ func main() {
 		chromedp.SendKeys(*passwordSelector, *password, chromedp.ByQuery, chromedp.NodeVisible),
 		chromedp.Sleep(time.Millisecond*time.Duration(*browserInputDelay)),
 		chromedp.Click(*submitButtonSelector, chromedp.ByQuery, chromedp.NodeVisible),
-	)
+	)...)
 
 	// Wrapping up
 	if err != nil {
@@ -106,3 +139,151 @@ func main() {
 		log.Println("Done")
 	}
 }
+
+// trustedKeys is the set of base64 encoded SHA-256 hashes of the trusted public keys
+type trustedKeys map[string]bool
+
+// loadTrustedKeys reads the CA certificates of the PEM file (optional) and the comma separated pins, like sha256/<base64> or <base64>
+func loadTrustedKeys(caFile string, pins string) (trustedKeys, error) {
+	keys := trustedKeys{}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		cas := 0
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("CA file %s: certificate %d: %w", caFile, cas+1, err)
+			}
+			if !cert.IsCA {
+				return nil, fmt.Errorf("CA file %s: certificate %d (%s) is not a CA certificate, use -spki-pins to trust a server certificate", caFile, cas+1, cert.Subject)
+			}
+			keys[spkiHash(cert)] = true
+			cas++
+		}
+		if cas == 0 {
+			return nil, fmt.Errorf("CA file %s contains no PEM encoded certificate", caFile)
+		}
+	}
+	for _, pin := range strings.Split(pins, ",") {
+		if pin = strings.TrimSpace(pin); pin == "" {
+			continue
+		}
+		hash := strings.TrimPrefix(pin, "sha256/")
+		if raw, err := base64.StdEncoding.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a base64 encoded SHA-256 hash of a public key, like sha256/AAAA...=", pin)
+		}
+		keys[hash] = true
+	}
+	return keys, nil
+}
+
+// spkiHash returns the base64 encoded SHA-256 hash of the public key of cert
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// browserFlag returns the value of the --ignore-certificate-errors-spki-list switch of the browser
+func (k trustedKeys) browserFlag() string {
+	hashes := make([]string, 0, len(k))
+	for hash := range k {
+		hashes = append(hashes, hash)
+	}
+	return strings.Join(hashes, ",")
+}
+
+// misuse returns why the chain presented by host must not be accepted, or "" if it may.
+// A chain valid by the system's trust store or not containing a trusted key is left to the browser.
+func (k trustedKeys) misuse(chain []*x509.Certificate, host string, allowed bool) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates}); err == nil {
+		return ""
+	}
+	roots := x509.NewCertPool()
+	pinned := false
+	for _, cert := range chain {
+		if k[spkiHash(cert)] {
+			roots.AddCert(cert)
+			pinned = true
+		}
+	}
+	if !pinned {
+		return ""
+	}
+	if !allowed {
+		return "the certificate is only accepted because of the trusted keys, which are only trusted on the host of the login URL"
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates, Roots: roots}); err != nil {
+		return "the certificate is not valid with the trusted keys: " + err.Error()
+	}
+	return ""
+}
+
+// trustOnlyOn blocks the https requests of the page whose certificate is only accepted because of the keys
+// on another host than the one of loginURL. The login host itself must present a certificate valid for its name
+// with the keys as roots. The certificate of each host is verified once, a host which can not be reached is blocked.
+// The requests are not checked anymore once the script exits, use webgenericcdp to scope the keys for the whole session.
+func (k trustedKeys) trustOnlyOn(loginURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		login, err := url.Parse(loginURL)
+		if err != nil {
+			return err
+		}
+		var mu sync.Mutex
+		verdicts := map[string]string{}
+		verdict := func(u *url.URL) string {
+			port := u.Port()
+			if port == "" {
+				port = "443"
+			}
+			addr := net.JoinHostPort(u.Hostname(), port)
+			mu.Lock()
+			defer mu.Unlock()
+			if reason, ok := verdicts[addr]; ok {
+				return reason
+			}
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: true})
+			if err != nil {
+				// Not remembered, the host is verified again by its next request
+				return "the certificate can not be verified: " + err.Error()
+			}
+			defer conn.Close()
+			verdicts[addr] = k.misuse(conn.ConnectionState().PeerCertificates, u.Hostname(), strings.EqualFold(u.Host, login.Host))
+			return verdicts[addr]
+		}
+		lctx := context.WithoutCancel(ctx)
+		c := chromedp.FromContext(ctx)
+		chromedp.ListenTarget(lctx, func(ev interface{}) {
+			paused, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			// Commands can not be sent from the listener, it would block the processing of the events
+			go func() {
+				ectx := cdp.WithExecutor(lctx, c.Target)
+				u, err := url.Parse(paused.Request.URL)
+				if err == nil && u.Scheme == "https" {
+					if reason := verdict(u); reason != "" {
+						log.Println("Request to " + u.Host + " blocked: " + reason)
+						fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(ectx)
+						return
+					}
+				}
+				fetch.ContinueRequest(paused.RequestID).Do(ectx)
+			}()
+		})
+		return fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: "https://*", RequestStage: fetch.RequestStageRequest}}).Do(ctx)
+	})
+}
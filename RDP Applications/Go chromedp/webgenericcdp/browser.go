@@ -0,0 +1,141 @@
+package main
+
+// Command line of the browser. The switches are collected in a browserLaunch, so the same browser can be started
+// by pipeAllocator with a debugging pipe (remoteDebugging=pipe) or by chromedp's ExecAllocator with a debugging
+// port on localhost (remoteDebugging=port).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Transports of the DevTools protocol between webgenericcdp and the browser
+const (
+	remoteDebuggingPipe = "pipe"
+	remoteDebuggingPort = "port"
+)
+
+// browserLaunch is the executable and the switches of the browser
+type browserLaunch struct {
+	execPath string                 // Empty for the Chrome found on the host
+	flags    map[string]interface{} // A string value is passed as --name=value, true as --name, false omits the switch
+}
+
+// Switches chromedp's ExecAllocator adds to the command line itself, which are set per transport
+var allocatorSwitches = map[string]bool{"user-data-dir": true, "remote-debugging-port": true, "no-sandbox": true}
+
+// errSwitchesRead stops the browser started to read the switches of chromedp before it runs
+var errSwitchesRead = errors.New("switches of chromedp read")
+
+// newBrowserLaunch returns the switches of chromedp.DefaultExecAllocatorOptions. chromedp keeps them private, they are
+// read from the command line of an ExecAllocator whose browser is not started.
+func newBrowserLaunch() *browserLaunch {
+	l := &browserLaunch{flags: map[string]interface{}{}}
+	var args []string
+	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.ModifyCmdFunc(func(cmd *exec.Cmd) {
+			args = cmd.Args[1:]
+			cmd.Err = errSwitchesRead
+		}))...)
+	defer cancel()
+	ctx, _ := chromedp.NewContext(allocCtx)
+	if err := chromedp.Run(ctx); !errors.Is(err, errSwitchesRead) {
+		panic(fmt.Sprintf("cannot read the switches of chromedp: %v", err))
+	}
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !strings.HasPrefix(arg, "--") || allocatorSwitches[name] {
+			continue
+		}
+		if hasValue {
+			l.flags[name] = value
+		} else {
+			l.flags[name] = true
+		}
+	}
+	return l
+}
+
+// flag sets a switch of the browser, value must be a string or a bool
+func (l *browserLaunch) flag(name string, value interface{}) {
+	l.flags[name] = value
+}
+
+// args returns the switches of the command line, sorted by name
+func (l *browserLaunch) args() []string {
+	var args []string
+	for name, value := range l.flags {
+		switch value := value.(type) {
+		case string:
+			args = append(args, fmt.Sprintf("--%s=%s", name, value))
+		case bool:
+			if value {
+				args = append(args, "--"+name)
+			}
+		}
+	}
+	sort.Strings(args)
+	return args
+}
+
+// path returns the executable of the browser, Chrome is looked up in the locations searched by chromedp
+func (l *browserLaunch) path() string {
+	if l.execPath != "" {
+		return l.execPath
+	}
+	var locations []string
+	switch runtime.GOOS {
+	case "windows":
+		locations = []string{
+			"chrome",
+			"chrome.exe",
+			`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+			`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+			os.Getenv("USERPROFILE") + `\AppData\Local\Google\Chrome\Application\chrome.exe`,
+			os.Getenv("USERPROFILE") + `\AppData\Local\Chromium\Application\chrome.exe`,
+		}
+	case "darwin":
+		locations = []string{
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+		}
+	default:
+		locations = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "/usr/bin/google-chrome", "chrome"}
+	}
+	for _, location := range locations {
+		if found, err := exec.LookPath(location); err == nil {
+			return found
+		}
+	}
+	return "chrome"
+}
+
+// execAllocatorOptions returns the options of chromedp's ExecAllocator starting the browser with a debugging port
+func (l *browserLaunch) execAllocatorOptions() []chromedp.ExecAllocatorOption {
+	var opts []chromedp.ExecAllocatorOption
+	if l.execPath != "" {
+		opts = append(opts, chromedp.ExecPath(l.execPath))
+	}
+	for name, value := range l.flags {
+		opts = append(opts, chromedp.Flag(name, value))
+	}
+	return opts
+}
+
+// newBrowserAllocator returns the parent context of the browser contexts and the option selecting the allocator of the transport.
+// The option must be passed to chromedp.NewContext.
+func newBrowserAllocator(launch *browserLaunch, transport string, uuid string) (context.Context, chromedp.ContextOption) {
+	if transport == remoteDebuggingPort {
+		allocCtx, _ := chromedp.NewExecAllocator(context.Background(), launch.execAllocatorOptions()...)
+		return allocCtx, func(*chromedp.Context) {}
+	}
+	return newPipeAllocator(context.Background(), launch, uuid)
+}
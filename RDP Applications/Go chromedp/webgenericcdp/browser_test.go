@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewBrowserLaunch(t *testing.T) {
+	launch := newBrowserLaunch()
+	for name, want := range map[string]interface{}{
+		"headless":          true,
+		"no-first-run":      true,
+		"enable-automation": true,
+		"disable-features":  "site-per-process,Translate,BlinkGenPropertyTrees",
+	} {
+		if got := launch.flags[name]; got != want {
+			t.Errorf("--%s: %v, want %v", name, got, want)
+		}
+	}
+	for name := range allocatorSwitches {
+		if _, ok := launch.flags[name]; ok {
+			t.Errorf("--%s is set by the transport", name)
+		}
+	}
+	for _, arg := range launch.args() {
+		if !strings.HasPrefix(arg, "--") {
+			t.Errorf("argument %q is not a switch", arg)
+		}
+	}
+}
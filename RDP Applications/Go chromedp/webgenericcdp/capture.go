@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+)
+
+// Prefix of the failure capture files in the log folder
+const failureCapturePrefix = "webgenericcdp_failure_"
+
+// Time limit of taking a failure capture, the login context may already be expired
+const failureCaptureTimeout = 20 * time.Second
+
+// Attribute marking the elements secrets were entered into while the capture is taken
+const secretMarkAttribute = "data-webgenericcdp-secret"
+
+// Masks the password inputs and the marked elements on the screen, in the frames whose document can be reached too,
+// remembers their original style and returns the number of marked elements masked
+const maskScript = `(() => {
+	const masked = [];
+	let marked = 0;
+	const mask = (doc) => {
+		doc.querySelectorAll('input[type=password], [` + secretMarkAttribute + `]').forEach(el => {
+			masked.push([el, el.getAttribute('style')]);
+			if (el.hasAttribute('` + secretMarkAttribute + `')) marked++;
+			el.style.setProperty('-webkit-text-security', 'disc', 'important');
+			el.style.setProperty('filter', 'blur(8px)', 'important');
+		});
+		doc.querySelectorAll('iframe, frame').forEach(f => {
+			let child = null;
+			try { child = f.contentDocument; } catch (e) {}
+			if (child) mask(child);
+		});
+	};
+	mask(document);
+	window.__webgenericcdpMasked = masked;
+	return marked;
+})()`
+
+// Restores the style of the masked elements and removes the marks, in every frame the marks could be set in
+const unmaskScript = `(() => {
+	(window.__webgenericcdpMasked || []).forEach(([el, style]) => {
+		if (style === null) el.removeAttribute('style'); else el.setAttribute('style', style);
+	});
+	delete window.__webgenericcdpMasked;
+	const unmark = (doc) => {
+		doc.querySelectorAll('[` + secretMarkAttribute + `]').forEach(el => el.removeAttribute('` + secretMarkAttribute + `'));
+		doc.querySelectorAll('iframe, frame').forEach(f => {
+			let child = null;
+			try { child = f.contentDocument; } catch (e) {}
+			if (child) unmark(child);
+		});
+	};
+	unmark(document);
+})()`
+
+// Returns the HTML of the page with the values of the inputs as attributes, so the snapshot shows what was entered.
+// Values of password, hidden and marked inputs and the text of marked elements are replaced, scripts are removed.
+const snapshotScript = `((hidden) => {
+	const clone = document.documentElement.cloneNode(true);
+	const live = document.querySelectorAll('input, textarea');
+	const copies = clone.querySelectorAll('input, textarea');
+	live.forEach((el, i) => {
+		const masked = el.type === 'password' || el.type === 'hidden' || el.hasAttribute('` + secretMarkAttribute + `');
+		const value = masked ? hidden : el.value;
+		if (el.tagName === 'TEXTAREA') copies[i].textContent = value; else copies[i].setAttribute('value', value);
+	});
+	clone.querySelectorAll('[` + secretMarkAttribute + `]').forEach(el => {
+		if (el.tagName !== 'INPUT' && el.tagName !== 'TEXTAREA') el.textContent = hidden;
+		el.removeAttribute('` + secretMarkAttribute + `');
+	});
+	clone.querySelectorAll('script, noscript').forEach(el => el.remove());
+	return '<!DOCTYPE html>\n' + clone.outerHTML;
+})(%s)`
+
+// failureCaptureSkipped returns why no capture may be taken of the page the login failed on, or "" if it may.
+// The page of an origin the credentials were withheld from, or whose certificate was rejected, is not the web application.
+func failureCaptureSkipped(ctx context.Context, err error, scope *certificateScope, uuid string) string {
+	var oerr *originMismatchError
+	switch {
+	case errors.As(err, &oerr):
+		return "the page is not on an allowed origin"
+	case strings.Contains(err.Error(), "net::ERR_CERT_"):
+		return "the certificate of the page was rejected"
+	case scope != nil:
+		// The blocked page replaces a page whose certificate is only accepted because of the trusted keys
+		var location string
+		lctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := chromedp.Run(lctx, chromedp.Location(&location)); err != nil {
+			return "the address of the page is unknown, its certificate can not be checked"
+		}
+		if scope.blocks(location, uuid) {
+			return "the certificate of the page was rejected"
+		}
+	}
+	return ""
+}
+
+// captureFailure saves a full-page screenshot and a sanitized DOM snapshot of the current page into dir, named after the session.
+// The inputs of type password and the elements of the secret actions are masked in both, in the frames too. The snapshot
+// only contains the top document; the screenshot is not taken if a secret element is in a frame which can not be masked.
+// Only the newest retention captures are kept.
+func captureFailure(ctx context.Context, dir string, uuid string, secretSelectors []elementSelector, retention int) {
+	ctx, cancel := context.WithTimeout(ctx, failureCaptureTimeout)
+	defer cancel()
+	base := dir + "\\" + failureCapturePrefix + time.Now().Format("20060102-150405") + "_" + uuid
+
+	marked := markSecretElements(ctx, secretSelectors, uuid)
+	defer func() {
+		if err := chromedp.Run(ctx, chromedp.Evaluate(unmaskScript, nil)); err != nil {
+			slog.Debug("[capture] Cannot restore the masked elements", "error", err.Error(), "sessionid", uuid)
+		}
+	}()
+
+	hidden, _ := json.Marshal(redactedText)
+	var html string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf(snapshotScript, hidden), &html)); err != nil {
+		slog.Error("[capture] Cannot take DOM snapshot", "error", err.Error(), "sessionid", uuid)
+	} else if err := os.WriteFile(base+".html", []byte(secrets.redact(html)), 0600); err != nil {
+		slog.Error("[capture] Cannot save DOM snapshot", "error", err.Error(), "sessionid", uuid)
+	} else {
+		slog.Info("[capture] DOM snapshot saved", "file", base+".html", "sessionid", uuid)
+	}
+
+	var png []byte
+	var masked int
+	if err := chromedp.Run(ctx, chromedp.Evaluate(maskScript, &masked)); err != nil {
+		slog.Error("[capture] Cannot mask the secret elements, screenshot skipped", "error", err.Error(), "sessionid", uuid)
+	} else if masked < marked {
+		slog.Warn("[capture] Screenshot skipped, a secret element is in a frame which can not be masked", "marked", marked, "masked", masked, "sessionid", uuid)
+	} else if err := chromedp.Run(ctx, chromedp.FullScreenshot(&png, 100)); err != nil {
+		slog.Error("[capture] Cannot take screenshot", "error", err.Error(), "sessionid", uuid)
+	} else if err := os.WriteFile(base+".png", png, 0600); err != nil {
+		slog.Error("[capture] Cannot save screenshot", "error", err.Error(), "sessionid", uuid)
+	} else {
+		slog.Info("[capture] Screenshot saved", "file", base+".png", "sessionid", uuid)
+	}
+
+	pruneFailureCaptures(dir, retention, uuid)
+}
+
+// markSecretElements marks the elements matching the selectors of the secret actions, so that the scripts can mask them,
+// and returns the number of marked elements. BySearch selectors find the elements of the frames too.
+func markSecretElements(ctx context.Context, selectors []elementSelector, uuid string) int {
+	marked := map[cdp.NodeID]bool{}
+	for _, sel := range selectors {
+		var nodes []*cdp.Node
+		qctx, cancel := context.WithTimeout(ctx, time.Second)
+		err := chromedp.Run(qctx, chromedp.Nodes(sel.query, &nodes, sel.option, chromedp.AtLeast(0)))
+		cancel()
+		if err != nil {
+			slog.Debug("[capture] Cannot look up secret element", "selector", sel.query, "error", err.Error(), "sessionid", uuid)
+			continue
+		}
+		for _, n := range nodes {
+			if err := chromedp.Run(ctx, dom.SetAttributeValue(n.NodeID, secretMarkAttribute, "1")); err != nil {
+				slog.Debug("[capture] Cannot mark secret element", "selector", sel.query, "error", err.Error(), "sessionid", uuid)
+				continue
+			}
+			marked[n.NodeID] = true
+		}
+	}
+	return len(marked)
+}
+
+// pruneFailureCaptures deletes the oldest captures of dir, keeping the newest retention ones
+func pruneFailureCaptures(dir string, retention int, uuid string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("[capture] Cannot list failure captures", "error", err.Error(), "sessionid", uuid)
+		return
+	}
+	// Files of a capture share their name, the timestamp in the name orders the captures
+	files := map[string][]string{}
+	var captures []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, failureCapturePrefix) {
+			continue
+		}
+		capture := strings.TrimSuffix(strings.TrimSuffix(name, ".png"), ".html")
+		if _, ok := files[capture]; !ok {
+			captures = append(captures, capture)
+		}
+		files[capture] = append(files[capture], name)
+	}
+	if len(captures) <= retention {
+		return
+	}
+	sort.Strings(captures)
+	for _, capture := range captures[:len(captures)-retention] {
+		for _, name := range files[capture] {
+			if err := os.Remove(dir + "\\" + name); err != nil {
+				slog.Error("[capture] Cannot delete old failure capture", "file", name, "error", err.Error(), "sessionid", uuid)
+			}
+		}
+	}
+}
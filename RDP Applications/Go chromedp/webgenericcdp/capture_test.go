@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFailureCaptureSkipped(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		reason string
+	}{
+		{errors.New("waiting for #user: context deadline exceeded"), ""},
+		{fmt.Errorf("s action: %w", &originMismatchError{origin: "https://evil.example", selector: "#pw", reason: "origin is not allowed"}), "the page is not on an allowed origin"},
+		{errors.New("page load error net::ERR_CERT_AUTHORITY_INVALID"), "the certificate of the page was rejected"},
+	} {
+		if got := failureCaptureSkipped(context.Background(), tc.err, nil, "test"); got != tc.reason {
+			t.Errorf("%v: %q, want %q", tc.err, got, tc.reason)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+// Trust of the certificates of the target issued by an internal CA, instead of ignoring every certificate error with browser_insecure.
+//
+// The keys of the CA certificates of trustedCAs and the trustedSPKIPins are passed to the browser as
+// --ignore-certificate-errors-spki-list: a certificate is accepted despite its errors only if its chain contains one
+// of these keys, every other certificate error still blocks the page. The switch applies to the whole browser and is
+// only honoured with --user-data-dir, which both launch modes always pass (chromedp creates a temporary profile).
+//
+// The CDP of the browser can not handle the certificate errors of the page itself, so the keys are scoped to the allowed
+// origins (url and idpDomains) through the Fetch domain: every https request of every window, subresources included,
+// is paused before the browser connects, and the certificate of its host is verified by a TLS connection of
+// webgenericcdp. A request is blocked if the certificate is only accepted because of the trusted keys and the host is
+// not allowed, or if it is allowed but the certificate is not valid for the host with the trusted keys as roots (the
+// browser ignores every error of a pinned chain, a wrong name or an expired certificate too). A host whose certificate
+// can not be retrieved by webgenericcdp, for example behind a proxy of the browser, is blocked until it can.
+//
+// The verification uses another connection than the browser, so the certificate of every response is compared with
+// the verified one through the security details of the Network domain. If the browser received another certificate,
+// its host is verified again and blocked for the rest of the session if no connection presents the certificate of the
+// browser, and the page is reloaded so the request is blocked.
+// WebSocket connections are not paused by the Fetch domain and are not checked.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/target"
+)
+
+const (
+	certificateCheckTimeout = 10 * time.Second // Timeout of the TLS connection verifying the certificate of a host
+	certificateRechecks     = 3                // Connections looking for the certificate received by the browser, if it differs from the verified one
+)
+
+// certificateTrust is the set of SPKI hashes (base64 encoded SHA-256 of the public key) trusted on the allowed origins
+type certificateTrust struct {
+	pins  map[string]bool
+	cas   int // Number of CA certificates of the bundle
+	file  string
+	extra int // Number of trustedSPKIPins
+}
+
+// loadCertificateTrust reads the PEM CA bundle (optional) and parses the SPKI pins, like sha256/<base64> or <base64>.
+// It returns nil if neither is configured.
+func loadCertificateTrust(caFile string, spkiPins []string) (*certificateTrust, error) {
+	if caFile == "" && len(spkiPins) == 0 {
+		return nil, nil
+	}
+	t := &certificateTrust{pins: map[string]bool{}, file: caFile}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("CA bundle %s: certificate %d: %w", caFile, t.cas+1, err)
+			}
+			if !cert.IsCA {
+				return nil, fmt.Errorf("CA bundle %s: certificate %d (%s) is not a CA certificate, use trustedSPKIPins to trust a server certificate", caFile, t.cas+1, cert.Subject)
+			}
+			t.pins[spkiHash(cert)] = true
+			t.cas++
+		}
+		if t.cas == 0 {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM encoded certificate", caFile)
+		}
+	}
+	for _, pin := range spkiPins {
+		hash, err := parseSPKIPin(pin)
+		if err != nil {
+			return nil, err
+		}
+		t.pins[hash] = true
+		t.extra++
+	}
+	return t, nil
+}
+
+// parseSPKIPin checks that pin is a base64 encoded SHA-256 hash, with or without the sha256/ prefix
+func parseSPKIPin(pin string) (string, error) {
+	hash := strings.TrimPrefix(pin, "sha256/")
+	if raw, err := base64.StdEncoding.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("%q is not a base64 encoded SHA-256 hash of a public key, like sha256/AAAA...=", pin)
+	}
+	return hash, nil
+}
+
+// spkiHash returns the base64 encoded SHA-256 hash of the public key of cert, as in the SPKI list of the browser
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// browserFlag returns the value of the --ignore-certificate-errors-spki-list switch of the browser
+func (t *certificateTrust) browserFlag() string {
+	pins := make([]string, 0, len(t.pins))
+	for pin := range t.pins {
+		pins = append(pins, pin)
+	}
+	sort.Strings(pins)
+	return strings.Join(pins, ",")
+}
+
+func (t *certificateTrust) String() string {
+	var parts []string
+	if t.cas > 0 {
+		parts = append(parts, strconv.Itoa(t.cas)+" CA certificate(s) of "+t.file)
+	}
+	if t.extra > 0 {
+		parts = append(parts, strconv.Itoa(t.extra)+" SPKI pin(s)")
+	}
+	return strings.Join(parts, " and ")
+}
+
+// misuse returns why the chain presented by host must not be accepted, or "" if it may. allowed reports whether the
+// host is on an allowed origin. A chain valid by the system's trust store or not containing a trusted key is left to the browser.
+func (t *certificateTrust) misuse(chain []*x509.Certificate, host string, allowed bool) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates}); err == nil {
+		return ""
+	}
+	roots := x509.NewCertPool()
+	pinned := false
+	for _, cert := range chain {
+		if t.pins[spkiHash(cert)] {
+			roots.AddCert(cert)
+			pinned = true
+		}
+	}
+	if !pinned {
+		// The browser rejects the certificate itself
+		return ""
+	}
+	if !allowed {
+		return "the certificate is only accepted because of the trusted keys, which are only trusted on the allowed origins"
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates, Roots: roots}); err != nil {
+		return "the certificate is not valid with the trusted keys: " + err.Error()
+	}
+	return ""
+}
+
+// certificateScope scopes the trusted keys to the allowed origins, see above
+type certificateScope struct {
+	trust *certificateTrust
+	guard *originGuard
+	dial  func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	checks map[string]*certificateCheck // By host:port
+}
+
+// certificateCheck is the result of the verification of the certificate of a host:port
+type certificateCheck struct {
+	mu       sync.Mutex
+	verified bool
+	leaf     *x509.Certificate // Certificate presented to webgenericcdp
+	reason   string            // Empty if the certificate is accepted
+}
+
+func newCertificateScope(trust *certificateTrust, guard *originGuard) *certificateScope {
+	dialer := &net.Dialer{Timeout: certificateCheckTimeout}
+	return &certificateScope{trust: trust, guard: guard, dial: dialer.DialContext, checks: map[string]*certificateCheck{}}
+}
+
+// entry returns the locked verification of the host:port of u, the caller must unlock it
+func (s *certificateScope) entry(u *url.URL) (c *certificateCheck, host string, addr string) {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = "443"
+	}
+	addr = net.JoinHostPort(host, port)
+	s.mu.Lock()
+	c, ok := s.checks[addr]
+	if !ok {
+		c = &certificateCheck{}
+		s.checks[addr] = c
+	}
+	s.mu.Unlock()
+	c.mu.Lock()
+	return c, host, addr
+}
+
+// verify verifies the certificate presented to webgenericcdp by the host:port of u, if it is not verified yet.
+// A certificate which can not be retrieved is not accepted, and retrieved again by the next verification.
+func (s *certificateScope) verify(c *certificateCheck, u *url.URL, host string, addr string, uuid string) string {
+	if c.verified {
+		return c.reason
+	}
+	chain, err := s.certificates(host, addr)
+	if err != nil {
+		slog.Debug("[certificateTrust] Cannot retrieve certificate", "host", addr, "error", err.Error(), "sessionid", uuid)
+		return "the certificate can not be verified: " + err.Error()
+	}
+	s.accept(c, chain, u, host)
+	slog.Debug("[certificateTrust] Certificate verified", "host", addr, "blocked", c.reason != "", "sessionid", uuid)
+	return c.reason
+}
+
+// accept records the verification of chain, presented by the host of u
+func (s *certificateScope) accept(c *certificateCheck, chain []*x509.Certificate, u *url.URL, host string) {
+	_, allowed := s.guard.allowed(originOf(u))
+	c.verified, c.leaf, c.reason = true, chain[0], s.trust.misuse(chain, host, allowed)
+}
+
+// check returns why the requests to u must be blocked, or "" if they are allowed.
+// The certificate of each host:port is verified once per session, and again when the browser receives another one.
+func (s *certificateScope) check(u *url.URL, uuid string) string {
+	c, host, addr := s.entry(u)
+	defer c.mu.Unlock()
+	return s.verify(c, u, host, addr, uuid)
+}
+
+// checkReceived returns why the requests to u must be blocked given the certificate received by the browser, or "" if
+// they are allowed. If it is not the verified certificate, the host is verified again until it presents the same one.
+func (s *certificateScope) checkReceived(u *url.URL, details *network.SecurityDetails, uuid string) string {
+	c, host, addr := s.entry(u)
+	defer c.mu.Unlock()
+	if reason := s.verify(c, u, host, addr, uuid); reason != "" || sameCertificate(c.leaf, details) {
+		return reason
+	}
+	for range certificateRechecks {
+		chain, err := s.certificates(host, addr)
+		if err != nil {
+			slog.Debug("[certificateTrust] Cannot retrieve certificate", "host", addr, "error", err.Error(), "sessionid", uuid)
+			continue
+		}
+		if sameCertificate(chain[0], details) {
+			s.accept(c, chain, u, host)
+			slog.Debug("[certificateTrust] Certificate verified again", "host", addr, "blocked", c.reason != "", "sessionid", uuid)
+			return c.reason
+		}
+	}
+	// Blocked for the rest of the session
+	c.reason = "the browser received another certificate than the one verified"
+	return c.reason
+}
+
+// sameCertificate reports whether the certificate received by the browser, described by its security details with the
+// common names of the subject and of the issuer, is leaf
+func sameCertificate(leaf *x509.Certificate, details *network.SecurityDetails) bool {
+	if details.ValidFrom == nil || details.ValidTo == nil ||
+		details.ValidFrom.Time().Unix() != leaf.NotBefore.Unix() || details.ValidTo.Time().Unix() != leaf.NotAfter.Unix() ||
+		details.SubjectName != leaf.Subject.CommonName || details.Issuer != leaf.Issuer.CommonName {
+		return false
+	}
+	names := append([]string(nil), leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	received := append([]string(nil), details.SanList...)
+	sort.Strings(names)
+	sort.Strings(received)
+	return strings.Join(names, "\n") == strings.Join(received, "\n")
+}
+
+// certificates returns the certificate chain presented by addr for host
+func (s *certificateScope) certificates(host string, addr string) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), certificateCheckTimeout)
+	defer cancel()
+	raw, err := s.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// The chain is verified by misuse, the connection only retrieves it
+	conn := tls.Client(raw, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// fetchPatterns returns the Fetch domain patterns of the https requests, paused before the browser connects
+func (s *certificateScope) fetchPatterns() []*fetch.RequestPattern {
+	return []*fetch.RequestPattern{{URLPattern: "https://*", RequestStage: fetch.RequestStageRequest}}
+}
+
+// paused blocks a request paused by the Fetch domain if the certificate of its host is not accepted, and reports whether it did.
+// A document gets the page telling the user that the address is not available, other requests fail.
+func (s *certificateScope) paused(ctx context.Context, _ target.ID, ev *fetch.EventRequestPaused, uuid string) bool {
+	u, err := url.Parse(ev.Request.URL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	reason := s.check(u, uuid)
+	if reason == "" {
+		return false
+	}
+	slog.Error("[certificateTrust] Request blocked", "event", "certificate_out_of_scope", "url", ev.Request.URL, "type", ev.ResourceType, "reason", reason, "allowed_origins", s.guard.String(), "sessionid", uuid)
+	if ev.ResourceType == network.ResourceTypeDocument {
+		err = fulfillBlockedPage(ctx, ev.RequestID, ev.Request.URL)
+	} else {
+		err = fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+	}
+	if err != nil {
+		slog.Error("[certificateTrust] Cannot block request", "error", err.Error(), "sessionid", uuid)
+	}
+	return true
+}
+
+// responded compares the certificate of a response with the verified one. If the response must be blocked, the page
+// of the target is reloaded, so its requests are paused and blocked.
+func (s *certificateScope) responded(ctx context.Context, _ target.ID, ev *network.EventResponseReceived, uuid string) {
+	// Responses fulfilled through the Fetch domain or served from the cache have no security details
+	u, err := url.Parse(ev.Response.URL)
+	if err != nil || u.Scheme != "https" || ev.Response.SecurityDetails == nil {
+		return
+	}
+	reason := s.checkReceived(u, ev.Response.SecurityDetails, uuid)
+	if reason == "" {
+		return
+	}
+	slog.Error("[certificateTrust] Response blocked", "event", "certificate_out_of_scope", "url", ev.Response.URL, "type", ev.Type, "reason", reason, "allowed_origins", s.guard.String(), "sessionid", uuid)
+	if err := page.Reload().Do(ctx); err != nil {
+		slog.Error("[certificateTrust] Cannot reload page", "error", err.Error(), "sessionid", uuid)
+	}
+}
+
+// blocks reports whether a page already loaded from rawURL must be reloaded through the scope
+func (s *certificateScope) blocks(rawURL string, uuid string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && s.check(u, uuid) != ""
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+func TestParseSPKIPin(t *testing.T) {
+	for _, tc := range []struct {
+		pin  string
+		hash string
+		err  string
+	}{
+		{pin: "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", hash: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{pin: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", hash: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{pin: "sha1/AAAA", err: "is not a base64 encoded SHA-256 hash"},
+		{pin: "AAAA", err: "is not a base64 encoded SHA-256 hash"},
+	} {
+		hash, err := parseSPKIPin(tc.pin)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.pin, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || hash != tc.hash {
+			t.Errorf("%s: %s, %v", tc.pin, hash, err)
+		}
+	}
+}
+
+// testCertificateScope returns a scope allowing the origin of guardURL whose connections all reach srv, and the number of connections
+func testCertificateScope(t *testing.T, srv *httptest.Server, pins []string, guardURL string) (*certificateScope, *atomic.Int32) {
+	t.Helper()
+	trust, err := loadCertificateTrust("", pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := url.Parse(guardURL)
+	guard, err := newOriginGuard(target, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := newCertificateScope(trust, guard)
+	dials := &atomic.Int32{}
+	scope.dial = func(ctx context.Context, network string, _ string) (net.Conn, error) {
+		dials.Add(1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	return scope, dials
+}
+
+func TestCertificateScope(t *testing.T) {
+	// The certificate of the test server is valid for 127.0.0.1 and example.com, issued by itself
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	pin := "sha256/" + spkiHash(srv.Certificate())
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	origin := func(host string) string { return "https://" + net.JoinHostPort(host, strconv.Itoa(port)) }
+
+	for _, tc := range []struct {
+		name    string
+		pins    []string
+		allowed string // Origin of the guard
+		request string
+		reason  string
+	}{
+		{name: "pinned on allowed origin", pins: []string{pin}, allowed: origin("127.0.0.1"), request: origin("127.0.0.1") + "/app"},
+		{name: "pinned on other origin", pins: []string{pin}, allowed: origin("127.0.0.1"), request: origin("example.com") + "/x.js", reason: "only trusted on the allowed origins"},
+		{name: "pinned with wrong name", pins: []string{pin}, allowed: origin("app.example.net"), request: origin("app.example.net") + "/", reason: "not valid with the trusted keys"},
+		{name: "not pinned", pins: []string{"sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, allowed: origin("127.0.0.1"), request: origin("example.com") + "/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scope, _ := testCertificateScope(t, srv, tc.pins, tc.allowed)
+			u, _ := url.Parse(tc.request)
+			reason := scope.check(u, "test")
+			if (tc.reason == "") != (reason == "") || !strings.Contains(reason, tc.reason) {
+				t.Errorf("reason %q, want %q", reason, tc.reason)
+			}
+			if got := scope.blocks(tc.request, "test"); got != (tc.reason != "") {
+				t.Errorf("blocks %t", got)
+			}
+		})
+	}
+
+	// The certificate of each host:port is verified once
+	scope, dials := testCertificateScope(t, srv, []string{pin}, origin("127.0.0.1"))
+	for _, request := range []string{origin("example.com") + "/a", origin("example.com") + "/b", origin("EXAMPLE.com") + "/c", origin("127.0.0.1") + "/"} {
+		u, _ := url.Parse(request)
+		scope.check(u, "test")
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("%d connections, want 2", n)
+	}
+
+	// A host webgenericcdp can not reach is blocked until it can, a verified host stays allowed
+	dial := scope.dial
+	scope.dial = func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("unreachable") }
+	if scope.blocks(origin("127.0.0.1")+"/", "test") {
+		t.Error("verified host blocked")
+	}
+	scope, _ = testCertificateScope(t, srv, []string{pin}, origin("127.0.0.1"))
+	scope.dial = func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("unreachable") }
+	if reason := scope.check(mustParseURL(origin("127.0.0.1")+"/"), "test"); !strings.Contains(reason, "can not be verified: unreachable") {
+		t.Errorf("unreachable host: reason %q", reason)
+	}
+	scope.dial = dial
+	if scope.blocks(origin("127.0.0.1")+"/", "test") {
+		t.Error("reachable host still blocked")
+	}
+}
+
+func mustParseURL(rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestCertificateScopeReceived(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	cert := srv.Certificate()
+	pin := "sha256/" + spkiHash(cert)
+	allowed := srv.URL + "/app"
+	details := func() *network.SecurityDetails {
+		var names []string
+		names = append(names, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+		from, to := cdp.TimeSinceEpoch(cert.NotBefore), cdp.TimeSinceEpoch(cert.NotAfter)
+		return &network.SecurityDetails{SubjectName: cert.Subject.CommonName, Issuer: cert.Issuer.CommonName, SanList: names, ValidFrom: &from, ValidTo: &to}
+	}
+
+	// The certificate received by the browser is the verified one
+	scope, dials := testCertificateScope(t, srv, []string{pin}, allowed)
+	d := details()
+	slices.Reverse(d.SanList)
+	if reason := scope.checkReceived(mustParseURL(allowed), d, "test"); reason != "" {
+		t.Errorf("same certificate: reason %q", reason)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("%d connections, want 1", n)
+	}
+
+	// Another certificate is looked for again, then blocked for the rest of the session
+	for _, change := range []func(d *network.SecurityDetails){
+		func(d *network.SecurityDetails) { d.Issuer = "Other CA" },
+		func(d *network.SecurityDetails) { d.SanList = d.SanList[1:] },
+		func(d *network.SecurityDetails) {
+			to := cdp.TimeSinceEpoch(cert.NotAfter.Add(time.Second))
+			d.ValidTo = &to
+		},
+	} {
+		scope, dials := testCertificateScope(t, srv, []string{pin}, allowed)
+		d := details()
+		change(d)
+		if reason := scope.checkReceived(mustParseURL(allowed), d, "test"); !strings.Contains(reason, "received another certificate") {
+			t.Errorf("%+v: reason %q", d, reason)
+		}
+		if n := dials.Load(); n != 1+certificateRechecks {
+			t.Errorf("%d connections, want %d", n, 1+certificateRechecks)
+		}
+		if !scope.blocks(allowed, "test") {
+			t.Error("host not blocked")
+		}
+	}
+}
+
+func TestCertificateScopeBrowser(t *testing.T) {
+	launch, _ := testBrowserLaunch(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>app</title>"))
+	}))
+	defer srv.Close()
+	pin := "sha256/" + spkiHash(srv.Certificate())
+	scope, dials := testCertificateScope(t, srv, []string{pin}, srv.URL)
+	launch.flag("ignore-certificate-errors-spki-list", spkiHash(srv.Certificate()))
+
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	if err := chromedp.Run(ctx, interceptRequests([]requestFilter{scope}, "test")); err != nil {
+		t.Fatal(err)
+	}
+	// The certificate received by the browser is the verified one, the page is not reloaded and blocked
+	var title string
+	if err := chromedp.Run(ctx, chromedp.Navigate(srv.URL), chromedp.Sleep(time.Second), chromedp.Title(&title)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "app" || dials.Load() != 1 {
+		t.Errorf("allowed origin: title %q, %d connections", title, dials.Load())
+	}
+	// localhost is not allowed, its pinned certificate is blocked
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	if err := chromedp.Run(ctx, chromedp.Navigate(localhost), chromedp.Title(&title)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Page not available" {
+		t.Errorf("other origin: title %q", title)
+	}
+}
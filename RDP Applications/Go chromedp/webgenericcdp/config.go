@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// configError is a single problem found in the configuration file.
+// Line and column are 1-based; zero means the problem is not bound to a position (e.g. a missing mandatory setting).
+type configError struct {
+	file   string
+	line   int
+	column int
+	msg    string
+}
+
+func (e *configError) Error() string {
+	switch {
+	case e.line > 0 && e.column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.file, e.line, e.column, e.msg)
+	case e.line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.file, e.msg)
+	}
+}
+
+// configErrors collects every problem found while loading a configuration file, so that all of them can be reported at once.
+type configErrors []*configError
+
+func (e configErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e configErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// splitErrors returns the individual errors of a joined error (like configErrors or the result of errors.Join), or err itself
+func splitErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// Accepted values of the enumeration-like settings
+var (
+	configBrowsers      = []string{"chrome", "edge"}
+	configLogging       = []string{"error", "info", "debug"}
+	configDebugging     = []string{remoteDebuggingPipe, remoteDebuggingPort}
+	configQueryOptions  = []string{"ByID", "ByQuery", "BySearch"}
+	configBoolAcceptMsg = "accepted values: 1, t, T, TRUE, true, True, 0, f, F, FALSE, false, False"
+)
+
+// loadConfig reads the configuration file at path on top of defaultConfig().
+func loadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return defaultConfig(), err
+	}
+	defer f.Close()
+	return parseConfig(path, f)
+}
+
+// parseConfig parses key=value lines on top of defaultConfig(). Lines starting with # and blank lines are ignored.
+// Values are taken verbatim after the first '=' (surrounding whitespace trimmed), so they may contain further '=' characters.
+// Parsing does not stop at the first problem, the returned error is a configErrors listing all of them.
+func parseConfig(name string, r io.Reader) (Config, error) {
+	config := defaultConfig()
+	var errs configErrors
+	addErr := func(line, column int, format string, args ...any) {
+		errs = append(errs, &configError{file: name, line: line, column: column, msg: fmt.Sprintf(format, args...)})
+	}
+
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	// loginActions may get long, allow lines up to 1 MiB
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := scanner.Text()
+		if lineNr == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		keyColumn := utf8.RuneCountInString(line[:strings.Index(line, trimmed)]) + 1
+		key, value, found := strings.Cut(trimmed, "=")
+		if !found {
+			addErr(lineNr, keyColumn+utf8.RuneCountInString(trimmed), "missing '=' after setting name %q", trimmed)
+			continue
+		}
+		key = strings.TrimSpace(key)
+		trimmedValue := strings.TrimLeft(value, " \t")
+		valueColumn := keyColumn + utf8.RuneCountInString(trimmed[:len(trimmed)-len(trimmedValue)])
+		value = strings.TrimRight(trimmedValue, " \t")
+
+		if prev, ok := seen[key]; ok {
+			addErr(lineNr, keyColumn, "duplicate setting %q, already set on line %d", key, prev)
+			continue
+		}
+		seen[key] = lineNr
+
+		var err error
+		switch key {
+		case "dumpStdinToLog":
+			config.dumpStdinToLog, err = parseConfigBool(value)
+		case "chromedp_logging":
+			config.chromedp_logging, err = parseConfigEnum(value, configLogging)
+		case "chromedp_queryOption":
+			config.chromedp_queryOption, err = parseConfigEnum(value, configQueryOptions)
+		case "url":
+			config.url = value
+			if _, terr := parseTemplate(value); terr != nil {
+				addErr(lineNr, valueColumn+terr.column-1, "invalid url: %s", terr.msg)
+			}
+		case "browser":
+			config.browser, err = parseConfigEnum(value, configBrowsers)
+		case "loginActions":
+			config.loginActions = value
+			if _, perr := parseLoginActions(value); perr != nil {
+				for _, e := range perr.(actionParseErrors) {
+					addErr(lineNr, valueColumn+e.column-1, "invalid loginActions: %s", e.msg)
+				}
+			}
+		case "loginFlow":
+			config.loginFlow = value
+			if value == "" {
+				addErr(lineNr, valueColumn, "loginFlow must not be empty, remove it to use loginActions")
+				continue
+			}
+			if !filepath.IsAbs(value) {
+				config.loginFlow = filepath.Join(filepath.Dir(name), value)
+			}
+			if _, ferr := loadLoginFlow(config.loginFlow); ferr != nil {
+				for _, e := range splitErrors(ferr) {
+					addErr(lineNr, valueColumn, "invalid loginFlow %s: %s", config.loginFlow, e)
+				}
+			}
+		case "sensitiveKeys":
+			config.sensitiveKeys = nil
+			for _, key := range strings.Split(value, ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					config.sensitiveKeys = append(config.sensitiveKeys, key)
+				}
+			}
+		case "trustedCAs":
+			config.trustedCAs = value
+			if value == "" {
+				addErr(lineNr, valueColumn, "trustedCAs must not be empty, remove it to use the trust store of the system only")
+				continue
+			}
+			if !filepath.IsAbs(value) {
+				config.trustedCAs = filepath.Join(filepath.Dir(name), value)
+			}
+			if _, terr := loadCertificateTrust(config.trustedCAs, nil); terr != nil {
+				addErr(lineNr, valueColumn, "invalid trustedCAs: %s", terr)
+			}
+		case "trustedSPKIPins":
+			config.trustedSPKIPins = nil
+			for _, pin := range strings.Split(value, ",") {
+				if pin = strings.TrimSpace(pin); pin == "" {
+					continue
+				}
+				if _, perr := parseSPKIPin(pin); perr != nil {
+					addErr(lineNr, valueColumn, "invalid trustedSPKIPins: %s", perr)
+					continue
+				}
+				config.trustedSPKIPins = append(config.trustedSPKIPins, pin)
+			}
+		case "navigationAllow", "navigationDeny":
+			var entries []string
+			for _, entry := range strings.Split(value, ",") {
+				if entry = strings.TrimSpace(entry); entry == "" {
+					continue
+				}
+				if _, nerr := parseNavigationRule(entry); nerr != nil {
+					addErr(lineNr, valueColumn, "invalid %s: %s", key, nerr)
+					continue
+				}
+				entries = append(entries, entry)
+			}
+			if key == "navigationAllow" {
+				config.navigationAllow = entries
+			} else {
+				config.navigationDeny = entries
+			}
+		case "idpDomains":
+			config.idpDomains = nil
+			for _, entry := range strings.Split(value, ",") {
+				if entry = strings.TrimSpace(entry); entry == "" {
+					continue
+				}
+				if _, rerr := parseOriginRule(entry); rerr != nil {
+					addErr(lineNr, valueColumn, "invalid idpDomains: %s", rerr)
+					continue
+				}
+				config.idpDomains = append(config.idpDomains, entry)
+			}
+		case "splitCharacters":
+			// Deprecated, templates accept any characters between the placeholders
+			config.splitCharacters = value
+		case "browserInputDelay":
+			config.browserInputDelay, err = parseConfigMilliseconds(value)
+		case "browser_incognito":
+			config.browser_incognito, err = parseConfigBool(value)
+		case "browser_insecure":
+			config.browser_insecure, err = parseConfigBool(value)
+		case "browser_kiosk":
+			config.browser_kiosk, err = parseConfigBool(value)
+		case "user_data_dir":
+			config.user_data_dir = value
+		case "basicAuthUsername":
+			config.basicAuthUsername = value
+			if _, terr := parseTemplate(value); terr != nil {
+				addErr(lineNr, valueColumn+terr.column-1, "invalid basicAuthUsername: %s", terr.msg)
+			}
+		case "loginTimeout":
+			config.loginTimeout, err = parseConfigDuration(value)
+		case "actionTimeout":
+			config.actionTimeout, err = parseConfigDuration(value)
+		case "successConditions", "failureConditions":
+			if _, perr := parseConditions(value, key == "failureConditions"); perr != nil {
+				for _, e := range perr.(actionParseErrors) {
+					addErr(lineNr, valueColumn+e.column-1, "invalid %s: %s", key, e.msg)
+				}
+			}
+			if key == "successConditions" {
+				config.successConditions = value
+			} else {
+				config.failureConditions = value
+			}
+		case "assertionTimeout":
+			config.assertionTimeout, err = parseConfigDuration(value)
+		case "remoteDebugging":
+			config.remoteDebugging, err = parseConfigEnum(value, configDebugging)
+		case "browserLockdown":
+			config.browserLockdown, err = parseConfigBool(value)
+		case "networkTrace":
+			config.networkTrace, err = parseConfigBool(value)
+		case "failureCapture":
+			config.failureCapture, err = parseConfigBool(value)
+		case "failureCaptureKeep":
+			config.failureCaptureKeep, err = strconv.Atoi(value)
+			if err != nil || config.failureCaptureKeep < 1 {
+				err = fmt.Errorf("%q is not a positive number, use failureCapture=false to disable the captures", value)
+			}
+		default:
+			addErr(lineNr, keyColumn, "unknown setting %q", key)
+			continue
+		}
+		if err != nil {
+			addErr(lineNr, valueColumn, "invalid value for %s: %s", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		addErr(lineNr+1, 0, "cannot read configuration: %s", err)
+	}
+
+	// Mandatory settings
+	if config.url == "" {
+		addErr(seen["url"], 0, "mandatory setting url is missing or empty")
+	}
+	if config.basicAuthUsername == "" {
+		addErr(seen["basicAuthUsername"], 0, "basicAuthUsername must not be empty, remove it or set it to false to use loginActions")
+	}
+	if config.basicAuthUsername == "false" && config.loginActions == "" && config.loginFlow == "" {
+		addErr(seen["loginActions"], 0, "mandatory setting loginActions is missing or empty (it may only be omitted if basicAuthUsername or loginFlow is configured)")
+	}
+	if config.loginFlow != "" && config.loginActions != "" {
+		addErr(seen["loginFlow"], 0, "loginFlow and loginActions can not be combined, the actions belong to the states of the flow")
+	}
+	if config.browser_insecure && (config.trustedCAs != "" || len(config.trustedSPKIPins) > 0) {
+		addErr(seen["browser_insecure"], 0, "browser_insecure can not be combined with trustedCAs or trustedSPKIPins, it ignores every certificate error")
+	}
+	if config.loginFlow != "" && config.basicAuthUsername != "false" {
+		addErr(seen["loginFlow"], 0, "loginFlow and basicAuthUsername can not be combined")
+	}
+
+	if len(errs) > 0 {
+		return config, errs
+	}
+	return config, nil
+}
+
+func parseConfigBool(value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%q is not a boolean, %s", value, configBoolAcceptMsg)
+	}
+	return b, nil
+}
+
+func parseConfigEnum(value string, accepted []string) (string, error) {
+	for _, a := range accepted {
+		if value == a {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not supported, accepted values: %s", value, strings.Join(accepted, "|"))
+}
+
+func parseConfigMilliseconds(value string) (int, error) {
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative number of milliseconds", value)
+	}
+	return ms, nil
+}
+
+// parseConfigDuration parses a timeout: a number of milliseconds or a Go duration like 90s. 0 disables the timeout.
+func parseConfigDuration(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	d, err := parseActionDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a non-negative number of milliseconds or a duration like 90s", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	src := "\ufeff# Sample\n" +
+		"\n" +
+		"url = https://app.example.com/login?a=b&c=d  \n" +
+		"\tloginActions=v::#user::{username}||c::#next\n" +
+		"browser=edge\n" +
+		"sensitiveKeys= apiKey, ,pin \n" +
+		"browserInputDelay=500\n" +
+		"loginTimeout=90000\n" +
+		"actionTimeout=2m\n" +
+		"assertionTimeout=0\n" +
+		"browser_incognito=false\n" +
+		"navigationAllow=docs.example.com/*, *.cdn.example.com\n" +
+		"trustedSPKIPins=sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=\n"
+	config, err := parseConfig("test.conf", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.url != "https://app.example.com/login?a=b&c=d" || config.loginActions != "v::#user::{username}||c::#next" || config.browser != "edge" {
+		t.Errorf("values: %q, %q, %q", config.url, config.loginActions, config.browser)
+	}
+	if strings.Join(config.sensitiveKeys, ",") != "apiKey,pin" || strings.Join(config.navigationAllow, ",") != "docs.example.com/*,*.cdn.example.com" || len(config.trustedSPKIPins) != 1 {
+		t.Errorf("lists: %q, %q, %q", config.sensitiveKeys, config.navigationAllow, config.trustedSPKIPins)
+	}
+	if config.browserInputDelay != 500 || config.loginTimeout != 90*time.Second || config.actionTimeout != 2*time.Minute || config.assertionTimeout != 0 {
+		t.Errorf("durations: %d, %s, %s, %s", config.browserInputDelay, config.loginTimeout, config.actionTimeout, config.assertionTimeout)
+	}
+	// Settings which are not configured keep their default
+	if config.browser_incognito || !config.browserLockdown || config.remoteDebugging != remoteDebuggingPipe || config.basicAuthUsername != "false" {
+		t.Errorf("defaults: %+v", config)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	// Prepended to the lines of the cases, unless noBase is set
+	const base = "url=https://app.example.com\nloginActions=c::#login\n"
+	for _, tc := range []struct {
+		name   string
+		src    string
+		noBase bool
+		errs   []string // Prefixes of the errors
+	}{
+		{
+			name: "unknown setting",
+			src:  "foo=bar",
+			errs: []string{`test.conf:3:1: unknown setting "foo"`},
+		},
+		{
+			name: "missing equal sign",
+			src:  "  noequals",
+			errs: []string{`test.conf:3:11: missing '=' after setting name "noequals"`},
+		},
+		{
+			name: "duplicate setting",
+			src:  "url=https://other.example.com",
+			errs: []string{`test.conf:3:1: duplicate setting "url", already set on line 1`},
+		},
+		{
+			name: "invalid values",
+			src:  "browser = firefox\n  loginTimeout=soon\nbrowser_kiosk=maybe\nfailureCaptureKeep=0",
+			errs: []string{
+				`test.conf:3:11: invalid value for browser: "firefox" is not supported, accepted values: chrome|edge`,
+				`test.conf:4:16: invalid value for loginTimeout: "soon" is not a non-negative number of milliseconds or a duration like 90s`,
+				`test.conf:5:15: invalid value for browser_kiosk: "maybe" is not a boolean, accepted values: 1, t`,
+				`test.conf:6:20: invalid value for failureCaptureKeep: "0" is not a positive number`,
+			},
+		},
+		{
+			name:   "errors of the loginActions at their column",
+			src:    "url=https://app.example.com\nloginActions=c::#a||x::#b||c::#c::d",
+			noBase: true,
+			errs: []string{
+				`test.conf:2:21: invalid loginActions: unknown action "x"`,
+				`test.conf:2:28: invalid loginActions: c action with improper number of configuration items`,
+			},
+		},
+		{
+			name: "errors of the templates and lists",
+			src:  "basicAuthUsername=corp\\{user\nnavigationDeny=ftp://x,app.example.com\nidpDomains=",
+			errs: []string{
+				`test.conf:3:24: invalid basicAuthUsername: placeholder is not closed with }`,
+				`test.conf:4:16: invalid navigationDeny: "ftp://x": scheme ftp is not supported`,
+			},
+		},
+		{
+			name:   "missing mandatory settings",
+			src:    "browser=chrome",
+			noBase: true,
+			errs: []string{
+				"test.conf: mandatory setting url is missing or empty",
+				"test.conf: mandatory setting loginActions is missing or empty",
+			},
+		},
+		{
+			name: "conflicting settings",
+			src:  "browser_insecure=true\ntrustedSPKIPins=sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=\nbasicAuthUsername=",
+			errs: []string{
+				"test.conf:5: basicAuthUsername must not be empty",
+				"test.conf:3: browser_insecure can not be combined with trustedCAs or trustedSPKIPins",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := tc.src
+			if !tc.noBase {
+				src = base + src
+			}
+			_, err := parseConfig("test.conf", strings.NewReader(src))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			errs := splitErrors(err)
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("%d errors, want %d:\n%s", len(errs), len(tc.errs), err)
+			}
+			for i, want := range tc.errs {
+				if !strings.HasPrefix(errs[i].Error(), want) {
+					t.Errorf("error %q, want %q", errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+// writeTestCA writes a self-signed CA certificate in PEM format to path
+func writeTestCA(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "flows"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	flow := `{"name":"test","states":{"_START_":{"next_states":["OK"]},"OK":{"patterns":["url::^https://app"],"event":"success"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "flows", "flow.json"), []byte(flow), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCA(t, filepath.Join(dir, "ca.pem"))
+
+	// Relative paths are resolved against the directory of the configuration file, not the working directory
+	path := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(path, []byte("url=https://app.example.com\nloginFlow=flows/flow.json\ntrustedCAs=ca.pem\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.loginFlow != filepath.Join(dir, "flows", "flow.json") || config.trustedCAs != filepath.Join(dir, "ca.pem") {
+		t.Errorf("loginFlow %s, trustedCAs %s", config.loginFlow, config.trustedCAs)
+	}
+
+	// Absolute paths are kept
+	other := t.TempDir()
+	writeTestCA(t, filepath.Join(other, "ca.pem"))
+	if err := os.WriteFile(path, []byte("url=https://app.example.com\nloginActions=c::#x\ntrustedCAs="+filepath.Join(other, "ca.pem")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = loadConfig(path); err != nil || config.trustedCAs != filepath.Join(other, "ca.pem") {
+		t.Errorf("trustedCAs %s, %v", config.trustedCAs, err)
+	}
+
+	// Errors name the resolved path
+	if err := os.WriteFile(path, []byte("url=https://app.example.com\nloginFlow=missing.json\ntrustedCAs=flows/flow.json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfig(path)
+	errs := splitErrors(err)
+	if len(errs) != 2 ||
+		!strings.HasPrefix(errs[0].Error(), path+":2:11: invalid loginFlow "+filepath.Join(dir, "missing.json")+": ") ||
+		!strings.HasPrefix(errs[1].Error(), path+":3:12: invalid trustedCAs: CA bundle "+filepath.Join(dir, "flows", "flow.json")+" contains no PEM encoded certificate") {
+		t.Errorf("errors:\n%v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+// State machine login flows, the alternative of loginActions for multi-page journeys, modelled on the
+// .tps pattern files of Safeguard for Privileged Sessions (see Terminal Pattern Files).
+//
+// A flow file is a JSON object:
+//
+//	{
+//	    "name": "Entra ID",
+//	    "description": "Entra ID sign-in with optional screens",
+//	    "states":
+//	    {
+//	        "_START_":  {"next_states": ["USERNAME"]},
+//	        "USERNAME": {"patterns": ["element::#i0116"], "actions": "v::#i0116::{username}||c::#idSIButton9", "next_states": ["PASSWORD"]},
+//	        "PASSWORD": {"patterns": ["element::#i0118"], "actions": "s::#i0118::password||c::#idSIButton9", "next_states": ["KMSI", "SUCCESS", "FAILURE"]},
+//	        "KMSI":     {"patterns": ["element::#KmsiCheckboxField"], "actions": "c::#idBtn_Back", "next_states": ["SUCCESS"]},
+//	        "SUCCESS":  {"patterns": ["url::^https://myapps\\.microsoft\\.com/"], "event": "success"},
+//	        "FAILURE":  {"patterns": ["element::#passwordError"], "event": "failure", "reason": "invalid-credentials"}
+//	    }
+//	}
+//
+// Patterns are the fingerprints of the page in the syntax of successConditions (url::, title::, text::, element::),
+// a state is recognized when every pattern of it matches. After the actions of a state, webgenericcdp waits for the first
+// of its next_states to be recognized. Actions use the syntax of loginActions. States with an event are terminal:
+// success ends the login, failure ends it with the exit code of its reason.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Name of the initial state, the page loaded from url
+const flowStartState = "_START_"
+
+// Number of times a state may be entered before the flow is regarded as looping
+const flowMaxVisits = 5
+
+// Events of the terminal states
+const (
+	flowEventSuccess = "success"
+	flowEventFailure = "failure"
+)
+
+// flowStateFile is a state as written in the flow file
+type flowStateFile struct {
+	Patterns   []string `json:"patterns"`
+	Actions    string   `json:"actions"`
+	NextStates []string `json:"next_states"`
+	Event      string   `json:"event"`
+	Reason     string   `json:"reason"`
+	Timeout    string   `json:"timeout"`
+}
+
+// flowState is a parsed state of a login flow
+type flowState struct {
+	name     string
+	patterns []loginCondition
+	actions  []loginAction
+	next     []string
+	event    string        // flowEventSuccess, flowEventFailure or empty
+	reason   string        // Failure states only
+	timeout  time.Duration // How long to wait for the next state, 0 if actionTimeout applies
+}
+
+// loginFlow is a parsed flow file
+type loginFlow struct {
+	name        string
+	description string
+	path        string
+	states      map[string]*flowState
+	order       []string // Names of the states in the order they are reached from _START_
+}
+
+// loadLoginFlow reads and checks the flow file at path. The returned error joins every problem found.
+func loadLoginFlow(path string) (*loginFlow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Name        string                   `json:"name"`
+		Description string                   `json:"description"`
+		States      map[string]flowStateFile `json:"states"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("not a valid flow file: %w", err)
+	}
+
+	flow := &loginFlow{name: file.Name, description: file.Description, path: path, states: map[string]*flowState{}}
+	var errs []error
+	names := make([]string, 0, len(file.States))
+	for name := range file.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state, serrs := parseFlowState(name, file.States[name])
+		flow.states[name] = state
+		errs = append(errs, serrs...)
+	}
+
+	start, ok := flow.states[flowStartState]
+	if !ok {
+		return flow, errors.Join(append(errs, errors.New("states: missing the "+flowStartState+" state"))...)
+	}
+	if len(start.patterns) > 0 || start.event != "" {
+		errs = append(errs, fmt.Errorf("states.%s: the start state can not have patterns or an event, it is the page loaded from url", flowStartState))
+	}
+	for _, name := range names {
+		for _, next := range flow.states[name].next {
+			if _, ok := flow.states[next]; !ok {
+				errs = append(errs, fmt.Errorf("states.%s.next_states: unknown state %q", name, next))
+			} else if next == flowStartState {
+				errs = append(errs, fmt.Errorf("states.%s.next_states: %s can not be a next state", name, flowStartState))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return flow, errors.Join(errs...)
+	}
+
+	// States reachable from _START_, breadth first
+	flow.order = []string{flowStartState}
+	success := false
+	for i := 0; i < len(flow.order); i++ {
+		state := flow.states[flow.order[i]]
+		success = success || state.event == flowEventSuccess
+		for _, next := range state.next {
+			if !slices.Contains(flow.order, next) {
+				flow.order = append(flow.order, next)
+			}
+		}
+	}
+	for _, name := range names {
+		if !slices.Contains(flow.order, name) {
+			errs = append(errs, fmt.Errorf("states.%s: not reachable from %s", name, flowStartState))
+		}
+	}
+	if !success {
+		errs = append(errs, errors.New("states: no state with event success is reachable from "+flowStartState))
+	}
+	return flow, errors.Join(errs...)
+}
+
+// parseFlowState parses and checks a state of the flow file
+func parseFlowState(name string, f flowStateFile) (*flowState, []error) {
+	state := &flowState{name: name, next: f.NextStates, event: f.Event, reason: f.Reason}
+	var errs []error
+	stateErr := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("states.%s%s: %s", name, field, fmt.Sprintf(format, args...)))
+	}
+
+	for i, p := range f.Patterns {
+		field := ".patterns[" + strconv.Itoa(i) + "]"
+		conditions, err := parseConditions(p, false)
+		switch {
+		case err != nil:
+			for _, perr := range splitErrors(err) {
+				stateErr(field, "%s", perr)
+			}
+		case len(conditions) != 1:
+			stateErr(field, "each pattern must be a single condition like element::<selector>, found %d", len(conditions))
+		default:
+			state.patterns = append(state.patterns, conditions[0])
+		}
+	}
+	if f.Actions != "" {
+		actions, err := parseLoginActions(f.Actions)
+		if err != nil {
+			for _, perr := range splitErrors(err) {
+				stateErr(".actions", "%s", perr)
+			}
+		}
+		state.actions = actions
+	}
+	if f.Timeout != "" {
+		d, err := parseActionDuration(f.Timeout)
+		if err != nil {
+			stateErr(".timeout", "%s", err)
+		}
+		state.timeout = d
+	}
+
+	switch f.Event {
+	case "":
+		if len(f.NextStates) == 0 {
+			stateErr("", "missing next_states, a state without an event must list the states which may follow it")
+		}
+		if f.Reason != "" {
+			stateErr(".reason", "reason is only supported with event failure")
+		}
+	case flowEventSuccess, flowEventFailure:
+		if f.Actions != "" || len(f.NextStates) > 0 {
+			stateErr("", "a state with event %s ends the flow, it can not have actions or next_states", f.Event)
+		}
+		if f.Event == flowEventFailure && f.Reason == "" {
+			state.reason = "failed"
+		}
+		if _, ok := failureReasons[state.reason]; f.Event == flowEventFailure && !ok {
+			stateErr(".reason", "%q is not supported, accepted values: account-locked|failed|invalid-credentials|password-expired", f.Reason)
+		}
+		if f.Event == flowEventSuccess && f.Reason != "" {
+			stateErr(".reason", "reason is only supported with event failure")
+		}
+	default:
+		stateErr(".event", "%q is not supported, accepted values: success|failure", f.Event)
+	}
+	if name != flowStartState && len(state.patterns) == 0 && len(f.Patterns) == 0 {
+		stateErr(".patterns", "missing patterns, the state can not be recognized")
+	}
+	return state, errs
+}
+
+// recognized reports whether every pattern of the state matches the current page
+func (s *flowState) recognized(ctx context.Context, queryOption chromedp.QueryOption, uuid string) (bool, error) {
+	for _, p := range s.patterns {
+		matched, err := p.matches(ctx, queryOption)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err != nil {
+			// The page may be navigating, the next poll checks again
+			slog.Debug("[loginFlow] Cannot check pattern", "state", s.name, "pattern", p.source, "error", err.Error(), "sessionid", uuid)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// describe returns the fingerprint of the state for the plan
+func (s *flowState) describe() string {
+	sources := make([]string, len(s.patterns))
+	for i, p := range s.patterns {
+		sources[i] = p.source
+	}
+	return strings.Join(sources, " and ")
+}
+
+// addLoginFlow appends the task performing the flow to the plan and returns the problems found
+func addLoginFlow(plan *taskPlan, config Config, queryOption chromedp.QueryOption, flow *loginFlow, launcherStdin map[string]interface{}, uuid string) []error {
+	var errs []error
+	plans := map[string]*taskPlan{}
+	desc := &taskPlan{}
+	for _, name := range flow.order {
+		state := flow.states[name]
+		sub := &taskPlan{}
+		for _, err := range addLoginActions(sub, config, queryOption, state.actions, launcherStdin, uuid) {
+			errs = append(errs, fmt.Errorf("loginFlow state %s: %w", name, err))
+		}
+		plans[name] = sub
+		switch {
+		case name == flowStartState:
+			desc.describe("State " + name + ":")
+		case state.event == flowEventSuccess:
+			desc.describe("State " + name + " if " + state.describe() + ": login succeeded")
+			continue
+		case state.event == flowEventFailure:
+			desc.describe("State " + name + " if " + state.describe() + ": login failed (" + state.reason + ", exit code " + strconv.Itoa(failureReasons[state.reason]) + ")")
+			continue
+		default:
+			desc.describe("State " + name + " if " + state.describe() + ":")
+		}
+		desc.nest(sub)
+		wait := "without time limit"
+		if timeout := flowStateTimeout(state, config); timeout > 0 {
+			wait = "within " + timeout.String()
+		}
+		desc.describe("    Next: " + strings.Join(state.next, ", ") + " (" + wait + ")")
+	}
+
+	title := "Perform loginFlow " + flow.path
+	if flow.name != "" {
+		title = "Perform loginFlow " + flow.name + " (" + flow.path + ")"
+	}
+	plan.add(runLoginFlow(flow, plans, queryOption, config, uuid), title+":")
+	plan.nest(desc)
+	slog.Debug("[taskList] Login flow", "name", flow.name, "path", flow.path, "states", len(flow.order), "sessionid", uuid)
+	return errs
+}
+
+// flowStateTimeout returns how long to wait for the next state after the actions of state
+func flowStateTimeout(state *flowState, config Config) time.Duration {
+	if state.timeout > 0 {
+		return state.timeout
+	}
+	return config.actionTimeout
+}
+
+// runLoginFlow performs the flow: the actions of the current state, then waiting for one of its next states, until a terminal state
+func runLoginFlow(flow *loginFlow, plans map[string]*taskPlan, queryOption chromedp.QueryOption, config Config, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		visits := map[string]int{}
+		state := flow.states[flowStartState]
+		for {
+			visits[state.name]++
+			if visits[state.name] > flowMaxVisits {
+				return fmt.Errorf("loginFlow state %s entered more than %d times, the flow is looping", state.name, flowMaxVisits)
+			}
+			switch state.event {
+			case flowEventSuccess:
+				slog.Info("[loginFlow] Login succeeded", "event", "login_succeeded", "state", state.name, "sessionid", uuid)
+				return nil
+			case flowEventFailure:
+				rerr := &loginResultError{reason: state.reason, condition: "state " + state.name + " (" + state.describe() + ")", exitCode: failureReasons[state.reason]}
+				slog.Error("[loginFlow] Login failed", "event", "login_failed", "reason", state.reason, "state", state.name, "exit_code", rerr.exitCode, "sessionid", uuid)
+				return rerr
+			}
+
+			slog.Debug("[loginFlow] Performing the actions of state "+state.name, "sessionid", uuid)
+			if err := chromedp.Tasks(plans[state.name].tasks).Do(ctx); err != nil {
+				return err
+			}
+			next, err := waitForFlowState(ctx, flow, state, flowStateTimeout(state, config), queryOption, uuid)
+			if err != nil {
+				return err
+			}
+			slog.Info("[loginFlow] State recognized", "state", next.name, "previous_state", state.name, "sessionid", uuid)
+			state = next
+		}
+	})
+}
+
+// waitForFlowState polls the next states of state until one of them is recognized, the first listed wins
+func waitForFlowState(ctx context.Context, flow *loginFlow, state *flowState, timeout time.Duration, queryOption chromedp.QueryOption, uuid string) (*flowState, error) {
+	start := time.Now()
+	for {
+		for _, name := range state.next {
+			next := flow.states[name]
+			ok, err := next.recognized(ctx, queryOption, uuid)
+			if err != nil {
+				return nil, stepError(ctx, ctx, "loginFlow state "+state.name, strings.Join(state.next, ", "), timeout, err)
+			}
+			if ok {
+				return next, nil
+			}
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, &timeoutError{step: "loginFlow state " + state.name, selector: "one of the next states " + strings.Join(state.next, ", "), timeout: timeout}
+		}
+		if err := chromedp.Sleep(waitPollInterval).Do(ctx); err != nil {
+			return nil, stepError(ctx, ctx, "loginFlow state "+state.name, strings.Join(state.next, ", "), timeout, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFlowFile writes a flow file with the states into a temporary folder and returns its path
+func writeFlowFile(t *testing.T, states string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "flow.json")
+	if err := os.WriteFile(path, []byte(`{"name":"test","states":{`+states+`}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLoginFlow(t *testing.T) {
+	path := writeFlowFile(t, `
+		"_START_":  {"next_states": ["USERNAME"]},
+		"USERNAME": {"patterns": ["element::#user"], "actions": "v::#user::{username}||c::#next", "next_states": ["PASSWORD"], "timeout": "20s"},
+		"PASSWORD": {"patterns": ["element::#pw", "title::Sign in"], "actions": "s::#pw::password||c::#next", "next_states": ["SUCCESS", "FAILURE"]},
+		"SUCCESS":  {"patterns": ["url::^https://app/"], "event": "success"},
+		"FAILURE":  {"patterns": ["element::#error"], "event": "failure"}`)
+	flow, err := loadLoginFlow(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(flow.order, ","); got != "_START_,USERNAME,PASSWORD,SUCCESS,FAILURE" {
+		t.Errorf("order %s", got)
+	}
+	if s := flow.states["USERNAME"]; len(s.actions) != 2 || s.timeout != 20*time.Second {
+		t.Errorf("USERNAME: %+v", s)
+	}
+	if s := flow.states["PASSWORD"]; s.describe() != "element::#pw and title::Sign in" {
+		t.Errorf("PASSWORD fingerprint: %s", s.describe())
+	}
+	if s := flow.states["FAILURE"]; s.reason != "failed" {
+		t.Errorf("default reason of FAILURE: %q", s.reason)
+	}
+}
+
+func TestLoadLoginFlowErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		states string
+		errs   []string
+	}{
+		{
+			name:   "missing start",
+			states: `"SUCCESS": {"patterns": ["url::x"], "event": "success"}`,
+			errs:   []string{"states: missing the _START_ state"},
+		},
+		{
+			name: "unknown next state",
+			states: `"_START_": {"next_states": ["NEXT"]},
+				"SUCCESS": {"patterns": ["url::x"], "event": "success"}`,
+			errs: []string{`states._START_.next_states: unknown state "NEXT"`},
+		},
+		{
+			name: "start as next state",
+			states: `"_START_": {"next_states": ["A"]},
+				"A": {"patterns": ["url::a"], "next_states": ["_START_", "SUCCESS"]},
+				"SUCCESS": {"patterns": ["url::x"], "event": "success"}`,
+			errs: []string{"states.A.next_states: _START_ can not be a next state"},
+		},
+		{
+			name: "start with pattern",
+			states: `"_START_": {"patterns": ["url::a"], "next_states": ["SUCCESS"]},
+				"SUCCESS": {"patterns": ["url::x"], "event": "success"}`,
+			errs: []string{"states._START_: the start state can not have patterns or an event"},
+		},
+		{
+			name: "unreachable",
+			states: `"_START_": {"next_states": ["SUCCESS"]},
+				"SUCCESS": {"patterns": ["url::x"], "event": "success"},
+				"ORPHAN": {"patterns": ["url::o"], "event": "failure"}`,
+			errs: []string{"states.ORPHAN: not reachable from _START_"},
+		},
+		{
+			name: "no success",
+			states: `"_START_": {"next_states": ["FAILURE"]},
+				"FAILURE": {"patterns": ["url::x"], "event": "failure"}`,
+			errs: []string{"states: no state with event success is reachable from _START_"},
+		},
+		{
+			name: "state errors",
+			states: `"_START_": {"next_states": ["A"]},
+				"A": {"patterns": ["url::a||title::b", "bogus::x"], "actions": "c::#a||x::y", "timeout": "soon", "reason": "failed", "next_states": ["SUCCESS"]},
+				"B": {"event": "success", "actions": "c::#b"},
+				"C": {"patterns": ["url::c"], "event": "failure", "reason": "tired"},
+				"D": {"patterns": ["url::d"], "event": "done"},
+				"E": {"patterns": ["url::e"]},
+				"SUCCESS": {"patterns": ["url::x"], "event": "success", "reason": "failed"}`,
+			errs: []string{
+				"states.A.patterns[0]: each pattern must be a single condition like element::<selector>, found 2",
+				`states.A.patterns[1]: column 1: unknown condition "bogus"`,
+				`states.A.actions: column 8: unknown action "x"`,
+				`states.A.timeout: "soon" must be a positive number of milliseconds`,
+				"states.A.reason: reason is only supported with event failure",
+				"states.B: a state with event success ends the flow, it can not have actions or next_states",
+				"states.B.patterns: missing patterns, the state can not be recognized",
+				`states.C.reason: "tired" is not supported`,
+				`states.D.event: "done" is not supported, accepted values: success|failure`,
+				"states.E: missing next_states",
+				"states.SUCCESS.reason: reason is only supported with event failure",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadLoginFlow(writeFlowFile(t, tc.states))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not contain %q:\n%s", want, err)
+				}
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "flow.json")
+	os.WriteFile(path, []byte(`{"states":{},"unknown":1}`), 0o600)
+	if _, err := loadLoginFlow(path); err == nil || !strings.Contains(err.Error(), "not a valid flow file") {
+		t.Errorf("unknown field: %v", err)
+	}
+}
+
+// testFlow returns a flow of states without patterns, every next state is recognized at once
+func testFlow(states ...*flowState) (*loginFlow, map[string]*taskPlan) {
+	flow := &loginFlow{states: map[string]*flowState{}}
+	plans := map[string]*taskPlan{}
+	for _, s := range states {
+		flow.states[s.name] = s
+		flow.order = append(flow.order, s.name)
+		plans[s.name] = &taskPlan{}
+	}
+	return flow, plans
+}
+
+func TestRunLoginFlow(t *testing.T) {
+	config := defaultConfig()
+
+	flow, plans := testFlow(&flowState{name: flowStartState, next: []string{"A"}}, &flowState{name: "A", next: []string{"OK"}}, &flowState{name: "OK", event: flowEventSuccess})
+	if err := runLoginFlow(flow, plans, nil, config, "test").Do(context.Background()); err != nil {
+		t.Errorf("success: %v", err)
+	}
+
+	flow, plans = testFlow(&flowState{name: flowStartState, next: []string{"LOCKED"}}, &flowState{name: "LOCKED", event: flowEventFailure, reason: "account-locked"})
+	var rerr *loginResultError
+	if err := runLoginFlow(flow, plans, nil, config, "test").Do(context.Background()); !errors.As(err, &rerr) || rerr.exitCode != exitAccountLocked {
+		t.Errorf("failure: %v", err)
+	}
+
+	// A and B follow each other until A is entered more than flowMaxVisits times
+	flow, plans = testFlow(&flowState{name: flowStartState, next: []string{"A"}}, &flowState{name: "A", next: []string{"B"}}, &flowState{name: "B", next: []string{"A"}})
+	err := runLoginFlow(flow, plans, nil, config, "test").Do(context.Background())
+	if err == nil || err.Error() != "loginFlow state A entered more than 5 times, the flow is looping" {
+		t.Errorf("loop: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+// Network trace of the login in HAR 1.2 format (http://www.softwareishard.com/blog/har-12-spec/), recorded from the
+// events of the CDP Network domain in every window, only in debug mode. The headers are taken from the ExtraInfo
+// events when they are received, as only they contain the Cookie header and the other headers added by the network
+// stack. The trace never contains request bodies, the values of the Authorization, Cookie and other credential
+// headers, the values of the credential parameters of the URLs, or the secrets known to webgenericcdp.
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// Headers whose values are always removed from the trace, besides the headers whose name is sensitive like X-Auth-Token
+var harSecretHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie"}
+
+// Parameters of the query and the fragment of the URLs whose values are removed from the trace, like the OAuth
+// authorization code and the SAML messages, besides the parameters whose name is sensitive like access_token
+var harCredentialParams = []string{"code", "samlrequest", "samlresponse", "samlart", "ticket", "sid", "session", "sessionid", "jsessionid", "phpsessid", "asp.net_sessionid"}
+
+// Headers holding a URL, whose credential parameters are removed
+var harURLHeaders = []string{"location", "referer", "content-location"}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+// harTimings are in milliseconds, -1 if not applicable
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+
+	start         time.Time // Monotonic timestamps of the browser
+	headersEnd    time.Time
+	hasResponse   bool
+	done          bool
+	requestExtra  bool // Headers taken from the ExtraInfo events
+	responseExtra bool
+}
+
+// harExtraInfo holds the headers of the ExtraInfo events received before the request or the response they belong to
+type harExtraInfo struct {
+	request  network.Headers
+	response network.Headers
+}
+
+// harRecorder collects the requests of the browser in the order they were sent
+type harRecorder struct {
+	mu      sync.Mutex
+	entries []*harEntry
+	pending map[network.RequestID]*harEntry
+	extra   map[network.RequestID]*harExtraInfo
+}
+
+func newHARRecorder() *harRecorder {
+	return &harRecorder{pending: map[network.RequestID]*harEntry{}, extra: map[network.RequestID]*harExtraInfo{}}
+}
+
+// start records the requests of the target of ctx and of the windows opened later for the whole browser session.
+// The first requests of a new window may be sent before they can be recorded.
+func (r *harRecorder) start(uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		attachNewWindows(ctx, func(wctx context.Context, info *target.Info) {
+			if err := chromedp.Run(wctx, r.record()); err != nil {
+				slog.Debug("[networkTrace] Cannot record the requests of new window", "url", info.URL, "error", err.Error(), "sessionid", uuid)
+			}
+		})
+		slog.Debug("[networkTrace] Recording network trace", "sessionid", uuid)
+		return r.record().Do(ctx)
+	})
+}
+
+// record enables the Network domain of the target of ctx and records its events
+func (r *harRecorder) record() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		chromedp.ListenTarget(context.WithoutCancel(ctx), r.handle)
+		return network.Enable().Do(ctx)
+	})
+}
+
+// handle records an event of the Network domain
+func (r *harRecorder) handle(ev any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if prev, ok := r.pending[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			// A redirect reuses the request id, the previous request ends with the redirect response
+			prev.setResponse(ev.RedirectResponse)
+			r.applyResponseExtra(ev.RequestID, prev)
+			prev.finish(ev.Timestamp, ev.RedirectResponse.EncodedDataLength)
+		}
+		e := newHAREntry(ev)
+		if x, ok := r.extra[ev.RequestID]; ok && x.request != nil {
+			e.Request.Headers = harHeaders(x.request)
+			e.requestExtra = true
+			x.request = nil
+		}
+		r.entries = append(r.entries, e)
+		r.pending[ev.RequestID] = e
+	case *network.EventRequestWillBeSentExtraInfo:
+		if e, ok := r.pending[ev.RequestID]; ok && !e.requestExtra {
+			e.Request.Headers = harHeaders(ev.Headers)
+			e.requestExtra = true
+		} else {
+			r.extraInfo(ev.RequestID).request = ev.Headers
+		}
+	case *network.EventResponseReceived:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.setResponse(ev.Response)
+			r.applyResponseExtra(ev.RequestID, e)
+		}
+	case *network.EventResponseReceivedExtraInfo:
+		if e, ok := r.pending[ev.RequestID]; ok && e.hasResponse && !e.responseExtra {
+			e.Response.Headers = harHeaders(ev.Headers)
+			e.responseExtra = true
+		} else {
+			r.extraInfo(ev.RequestID).response = ev.Headers
+		}
+	case *network.EventLoadingFinished:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.finish(ev.Timestamp, ev.EncodedDataLength)
+			delete(r.pending, ev.RequestID)
+			delete(r.extra, ev.RequestID)
+		}
+	case *network.EventLoadingFailed:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.Response.Comment = ev.ErrorText
+			if ev.BlockedReason != "" {
+				e.Response.Comment += " (blocked: " + string(ev.BlockedReason) + ")"
+			}
+			e.finish(ev.Timestamp, 0)
+			delete(r.pending, ev.RequestID)
+			delete(r.extra, ev.RequestID)
+		}
+	}
+}
+
+// extraInfo returns the headers of the ExtraInfo events waiting for the request id, r.mu must be held
+func (r *harRecorder) extraInfo(id network.RequestID) *harExtraInfo {
+	x, ok := r.extra[id]
+	if !ok {
+		x = &harExtraInfo{}
+		r.extra[id] = x
+	}
+	return x
+}
+
+// applyResponseExtra replaces the response headers of e by the ones of a ResponseReceivedExtraInfo event received before, r.mu must be held
+func (r *harRecorder) applyResponseExtra(id network.RequestID, e *harEntry) {
+	if x, ok := r.extra[id]; ok && x.response != nil && !e.responseExtra {
+		e.Response.Headers = harHeaders(x.response)
+		e.responseExtra = true
+		x.response = nil
+	}
+}
+
+func newHAREntry(ev *network.EventRequestWillBeSent) *harEntry {
+	req := ev.Request
+	e := &harEntry{
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         harURL(req.URL + req.URLFragment),
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Headers),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+		},
+		Response: harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Timings:  harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+	if ev.WallTime != nil {
+		e.StartedDateTime = ev.WallTime.Time().Format(time.RFC3339Nano)
+	}
+	if ev.Timestamp != nil {
+		e.start = ev.Timestamp.Time()
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				if harCredentialParam(name) {
+					v = redactedText
+				}
+				e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: name, Value: v})
+			}
+		}
+		sort.Slice(e.Request.QueryString, func(i, j int) bool { return e.Request.QueryString[i].Name < e.Request.QueryString[j].Name })
+	}
+	if req.HasPostData {
+		// Request bodies carry the credentials of login forms, only their presence is recorded
+		var mimeType string
+		for name, value := range req.Headers {
+			if strings.EqualFold(name, "Content-Type") {
+				mimeType, _ = value.(string)
+			}
+		}
+		e.Request.PostData = &harPostData{MimeType: mimeType, Text: redactedText}
+		e.Request.BodySize = -1
+	}
+	return e
+}
+
+// setResponse records the response headers and the timings of the request
+func (e *harEntry) setResponse(resp *network.Response) {
+	e.hasResponse = true
+	e.Response.Status = resp.Status
+	e.Response.StatusText = resp.StatusText
+	e.Response.HTTPVersion = harHTTPVersion(resp.Protocol)
+	e.Request.HTTPVersion = e.Response.HTTPVersion
+	e.Response.Headers = harHeaders(resp.Headers)
+	e.Response.Content.MimeType = resp.MimeType
+	for _, h := range e.Response.Headers {
+		if strings.EqualFold(h.Name, "Location") {
+			e.Response.RedirectURL = h.Value
+		}
+	}
+	e.ServerIPAddress = resp.RemoteIPAddress
+	if t := resp.Timing; t != nil {
+		span := func(start, end float64) float64 {
+			if start < 0 || end < 0 {
+				return -1
+			}
+			return end - start
+		}
+		e.Timings.DNS = span(t.DNSStart, t.DNSEnd)
+		e.Timings.Connect = span(t.ConnectStart, t.ConnectEnd)
+		e.Timings.SSL = span(t.SslStart, t.SslEnd)
+		e.Timings.Send = max(span(t.SendStart, t.SendEnd), 0)
+		e.Timings.Wait = max(span(t.SendEnd, t.ReceiveHeadersEnd), 0)
+		// RequestTime is in seconds on the monotonic clock of the events, the other timings are milliseconds relative to it
+		e.headersEnd = cdp.MonotonicTimeEpoch.Add(time.Duration(t.RequestTime*float64(time.Second) + t.ReceiveHeadersEnd*float64(time.Millisecond)))
+	}
+}
+
+// finish records the end of the request at the monotonic timestamp ts
+func (e *harEntry) finish(ts *cdp.MonotonicTime, encodedDataLength float64) {
+	e.done = true
+	if e.hasResponse {
+		e.Response.BodySize = int64(encodedDataLength)
+		e.Response.Content.Size = int64(encodedDataLength)
+	}
+	if ts == nil || e.start.IsZero() {
+		return
+	}
+	end := ts.Time()
+	e.Time = float64(end.Sub(e.start)) / float64(time.Millisecond)
+	if !e.headersEnd.IsZero() {
+		e.Timings.Receive = max(float64(end.Sub(e.headersEnd))/float64(time.Millisecond), 0)
+	}
+}
+
+// harHeaders converts the headers of a CDP event, removing the values of the credential headers
+func harHeaders(headers network.Headers) []harNameValue {
+	list := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		text, _ := value.(string)
+		lower := strings.ToLower(name)
+		for _, secret := range harSecretHeaders {
+			if lower == secret {
+				text = redactedText
+			}
+		}
+		if isSensitiveKey(strings.ReplaceAll(lower, "-", ""), nil) {
+			text = redactedText
+		}
+		if slices.Contains(harURLHeaders, lower) {
+			text = harURL(text)
+		}
+		list = append(list, harNameValue{Name: name, Value: text})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// harCredentialParam reports whether the value of the URL parameter name is removed from the trace
+func harCredentialParam(name string) bool {
+	return slices.Contains(harCredentialParams, strings.ToLower(name)) || isSensitiveKey(strings.NewReplacer("_", "", "-", "").Replace(name), nil)
+}
+
+// harURL returns rawURL with the values of the credential parameters of its query and of its fragment removed, like
+// the tokens of the OAuth implicit flow in #access_token=...&id_token=... The fragment may hold a query of its own,
+// like #/callback?code=...
+func harURL(rawURL string) string {
+	rest, fragment, hasFragment := strings.Cut(rawURL, "#")
+	base, query, hasQuery := strings.Cut(rest, "?")
+	if hasQuery {
+		base += "?" + harParams(query)
+	}
+	if hasFragment {
+		if route, query, found := strings.Cut(fragment, "?"); found {
+			fragment = route + "?" + harParams(query)
+		} else {
+			fragment = harParams(fragment)
+		}
+		base += "#" + fragment
+	}
+	return base
+}
+
+// harParams removes the values of the credential parameters of an encoded query, keeping the other parameters as they are
+func harParams(query string) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, found := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); found && err == nil && harCredentialParam(decoded) {
+			params[i] = name + "=" + url.QueryEscape(redactedText)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// harHTTPVersion converts the protocol reported by the browser, like h2 or http/1.1, to the HTTP version of HAR
+func harHTTPVersion(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "h2":
+		return "HTTP/2.0"
+	case "h3", "h3-29":
+		return "HTTP/3.0"
+	default:
+		return strings.ToUpper(protocol)
+	}
+}
+
+// save writes the trace recorded so far to path. Known secrets are removed from the whole file.
+func (r *harRecorder) save(path string, uuid string) {
+	r.mu.Lock()
+	for _, e := range r.entries {
+		if !e.done && e.Response.Comment == "" {
+			e.Response.Comment = "no response before the trace was saved"
+		}
+	}
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	har := map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "webgenericcdp", "version": version},
+			"entries": r.entries,
+			"comment": "Request bodies, credential headers and secrets are removed",
+		},
+	}
+	data, err := json.MarshalIndent(har, "", "  ")
+	count := len(r.entries)
+	r.mu.Unlock()
+	if err != nil {
+		slog.Error("[networkTrace] Cannot encode network trace", "error", err.Error(), "sessionid", uuid)
+		return
+	}
+	if err := os.WriteFile(path, []byte(secrets.redact(string(data))), 0600); err != nil {
+		slog.Error("[networkTrace] Cannot save network trace", "file", path, "error", err.Error(), "sessionid", uuid)
+		return
+	}
+	slog.Info("[networkTrace] Network trace saved", "file", path, "requests", count, "sessionid", uuid)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// harHeader returns the value of the header name of list, "" if it is missing
+func harHeader(list []harNameValue, name string) string {
+	for _, h := range list {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+func TestHARRecorderExtraInfo(t *testing.T) {
+	r := newHARRecorder()
+	sent := func(id network.RequestID, rawURL string, redirect *network.Response) {
+		r.handle(&network.EventRequestWillBeSent{RequestID: id, RedirectResponse: redirect,
+			Request: &network.Request{Method: "GET", URL: rawURL, Headers: network.Headers{"Accept": "*/*"}}})
+	}
+
+	// ExtraInfo after the request, the redirect response headers before the redirect
+	sent("1", "https://app.example.com/", nil)
+	r.handle(&network.EventRequestWillBeSentExtraInfo{RequestID: "1", Headers: network.Headers{"Accept": "*/*", "Cookie": "sid=secret"}})
+	r.handle(&network.EventResponseReceivedExtraInfo{RequestID: "1", Headers: network.Headers{"Location": "/login", "Set-Cookie": "sid=new"}})
+	// ExtraInfo of the second request before the request
+	r.handle(&network.EventRequestWillBeSentExtraInfo{RequestID: "1", Headers: network.Headers{"Authorization": "Basic secret", "Cookie": "sid=new"}})
+	sent("1", "https://app.example.com/login", &network.Response{Status: 302, Headers: network.Headers{"Location": "/login"}})
+	r.handle(&network.EventResponseReceived{RequestID: "1", Response: &network.Response{Status: 200, Headers: network.Headers{"Content-Type": "text/html"}}})
+	r.handle(&network.EventLoadingFinished{RequestID: "1"})
+
+	// A request without ExtraInfo keeps the headers of its events
+	sent("2", "https://app.example.com/app.js", nil)
+	r.handle(&network.EventResponseReceived{RequestID: "2", Response: &network.Response{Status: 200, Headers: network.Headers{"Content-Type": "text/javascript"}}})
+	r.handle(&network.EventLoadingFinished{RequestID: "2"})
+
+	if len(r.entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(r.entries))
+	}
+	first, second, script := r.entries[0], r.entries[1], r.entries[2]
+	if got := harHeader(first.Request.Headers, "Cookie"); got != redactedText {
+		t.Errorf("Cookie of the first request: %q", got)
+	}
+	if first.Response.Status != 302 || harHeader(first.Response.Headers, "Set-Cookie") != redactedText || first.Response.RedirectURL != "/login" {
+		t.Errorf("redirect response: %+v", first.Response)
+	}
+	if harHeader(second.Request.Headers, "Authorization") != redactedText || harHeader(second.Request.Headers, "Cookie") != redactedText {
+		t.Errorf("headers of the second request: %+v", second.Request.Headers)
+	}
+	if second.Response.Status != 200 || harHeader(second.Response.Headers, "Content-Type") != "text/html" {
+		t.Errorf("response of the second request: %+v", second.Response)
+	}
+	if harHeader(script.Request.Headers, "Accept") != "*/*" || harHeader(script.Response.Headers, "Content-Type") != "text/javascript" {
+		t.Errorf("request without ExtraInfo: %+v", script)
+	}
+	if len(r.pending) != 0 || len(r.extra) != 0 {
+		t.Errorf("%d pending requests, %d ExtraInfo left", len(r.pending), len(r.extra))
+	}
+}
+
+func TestHARURL(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		want string
+	}{
+		{url: "https://app.example.com/home?tab=1#top", want: "https://app.example.com/home?tab=1#top"},
+		{url: "https://app.example.com/cb?code=abc123&state=xyz", want: "https://app.example.com/cb?code=%3Chidden%3E&state=xyz"},
+		{url: "https://app.example.com/cb#access_token=eyJ&token_type=Bearer&id_token=eyJ2&expires_in=3600", want: "https://app.example.com/cb#access_token=%3Chidden%3E&token_type=%3Chidden%3E&id_token=%3Chidden%3E&expires_in=3600"},
+		{url: "https://app.example.com/#/callback?code=abc&state=1", want: "https://app.example.com/#/callback?code=%3Chidden%3E&state=1"},
+		{url: "https://idp.example.com/sso?SAMLRequest=fZJN&RelayState=%2Fapp", want: "https://idp.example.com/sso?SAMLRequest=%3Chidden%3E&RelayState=%2Fapp"},
+		{url: "/acs?SAMLResponse=PHNhbWw%3D", want: "/acs?SAMLResponse=%3Chidden%3E"},
+		{url: "https://app.example.com/?JSESSIONID=1&sessionId=2&Refresh_Token=3&api%5Fkey=4&x", want: "https://app.example.com/?JSESSIONID=%3Chidden%3E&sessionId=%3Chidden%3E&Refresh_Token=%3Chidden%3E&api%5Fkey=%3Chidden%3E&x"},
+		{url: "https://app.example.com/a?b", want: "https://app.example.com/a?b"},
+	} {
+		if got := harURL(tc.url); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestHARRecorderCredentialParams(t *testing.T) {
+	r := newHARRecorder()
+	r.handle(&network.EventRequestWillBeSent{RequestID: "1", Request: &network.Request{Method: "GET",
+		URL: "https://app.example.com/cb?code=abc123&state=xyz", URLFragment: "#id_token=eyJ",
+		Headers: network.Headers{"Referer": "https://idp.example.com/authorize?client_id=app&client_secret=s3cr3t"}}})
+	r.handle(&network.EventResponseReceived{RequestID: "1", Response: &network.Response{Status: 302,
+		Headers: network.Headers{"Location": "https://app.example.com/home#access_token=eyJ&state=xyz"}}})
+	r.handle(&network.EventLoadingFinished{RequestID: "1"})
+
+	if len(r.entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(r.entries))
+	}
+	e := r.entries[0]
+	if e.Request.URL != "https://app.example.com/cb?code=%3Chidden%3E&state=xyz#id_token=%3Chidden%3E" {
+		t.Errorf("URL %s", e.Request.URL)
+	}
+	want := []harNameValue{{Name: "code", Value: redactedText}, {Name: "state", Value: "xyz"}}
+	if !slices.Equal(e.Request.QueryString, want) {
+		t.Errorf("query string %+v", e.Request.QueryString)
+	}
+	if got := harHeader(e.Request.Headers, "Referer"); got != "https://idp.example.com/authorize?client_id=app&client_secret=%3Chidden%3E" {
+		t.Errorf("Referer %s", got)
+	}
+	location := "https://app.example.com/home#access_token=%3Chidden%3E&state=xyz"
+	if e.Response.RedirectURL != location || harHeader(e.Response.Headers, "Location") != location {
+		t.Errorf("redirect %s, Location %s", e.Response.RedirectURL, harHeader(e.Response.Headers, "Location"))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/security"
+	"github.com/chromedp/chromedp"
+)
+
+// Number of times the credentials are offered for a request before the challenge is cancelled (wrong password)
+const httpAuthMaxAttempts = 2
+
+// originOf returns the origin of u as the browser reports it: scheme://host[:port] without the default port
+func originOf(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if port == "" || (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		return scheme + "://" + host
+	}
+	return scheme + "://" + host + ":" + port
+}
+
+// httpAuthWithheld returns why the credentials must not be sent for the challenge, or "" if they may. The credentials are
+// withheld from the origins not allowed by guard, from Basic authentication over http, which sends the password in clear
+// text, and from an https origin whose last document was not loaded with a secure TLS state. Before the first document
+// of an origin is loaded its state is unknown: the browser only sends the request after accepting the certificate.
+func httpAuthWithheld(guard *originGuard, challenge *fetch.AuthChallenge) string {
+	u, err := url.Parse(challenge.Origin)
+	if err != nil {
+		return "invalid origin"
+	}
+	if _, allowed := guard.allowed(challenge.Origin); !allowed {
+		return "origin is not allowed, allowed origins: " + guard.String()
+	}
+	if u.Scheme == "http" && strings.EqualFold(challenge.Scheme, "basic") {
+		return "Basic authentication over http would send the password in clear text"
+	}
+	if u.Scheme == "https" && !guard.insecure {
+		if state, seen := guard.state(originOf(u)); seen && state != security.StateSecure {
+			return "TLS state of the origin is " + string(state) + ", not secure"
+		}
+	}
+	return ""
+}
+
+// answerHTTPAuth answers the HTTP authentication challenges (Basic, Digest, NTLM, Negotiate) of the allowed origins
+// with the credentials through the CDP Fetch domain, so that the password never appears in a URL.
+// Challenges of other origins and of proxies are never answered with the credentials, see httpAuthWithheld.
+// The handler stays active for the whole browser session, the attempts of a request are forgotten when it completes.
+// The Fetch domain pauses the requests checked by the filters too, if configured. guard must be started before.
+func answerHTTPAuth(guard *originGuard, filters []requestFilter, username string, password string, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		// The handler must outlive the login context, the browser keeps pausing the requests of the origin
+		lctx := context.WithoutCancel(ctx)
+		c := chromedp.FromContext(ctx)
+		var mu sync.Mutex
+		attempts := map[fetch.RequestID]int{}
+		requests := map[network.RequestID]fetch.RequestID{} // Paused requests by their Network domain id, until they complete
+		forget := func(id network.RequestID) {
+			mu.Lock()
+			if rid, ok := requests[id]; ok {
+				delete(attempts, rid)
+				delete(requests, id)
+			}
+			mu.Unlock()
+		}
+		chromedp.ListenTarget(lctx, func(ev any) {
+			switch ev := ev.(type) {
+			case *fetch.EventRequestPaused:
+				if ev.NetworkID != "" {
+					mu.Lock()
+					requests[ev.NetworkID] = ev.RequestID
+					mu.Unlock()
+				}
+				go filterPaused(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			case *network.EventResponseReceived:
+				go filterResponse(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			case *page.EventFrameNavigated:
+				go filterNavigated(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			case *network.EventLoadingFinished:
+				forget(ev.RequestID)
+			case *network.EventLoadingFailed:
+				forget(ev.RequestID)
+			case *fetch.EventAuthRequired:
+				response := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+				challenge := ev.AuthChallenge
+				mu.Lock()
+				attempts[ev.RequestID]++
+				attempt := attempts[ev.RequestID]
+				mu.Unlock()
+				switch reason := httpAuthWithheld(guard, challenge); {
+				case challenge.Source == fetch.AuthChallengeSourceProxy:
+					slog.Debug("[httpAuth] Leaving proxy authentication challenge to the browser", "origin", challenge.Origin, "sessionid", uuid)
+				case reason != "":
+					slog.Error("[credentialOrigin] Credentials withheld", "event", "credential_origin_mismatch", "origin", challenge.Origin, "scheme", challenge.Scheme, "reason", reason, "sessionid", uuid)
+					response.Response = fetch.AuthChallengeResponseResponseCancelAuth
+				case attempt > httpAuthMaxAttempts:
+					slog.Error("[httpAuth] Credentials were rejected", "origin", challenge.Origin, "scheme", challenge.Scheme, "realm", challenge.Realm, "sessionid", uuid)
+					response.Response = fetch.AuthChallengeResponseResponseCancelAuth
+				default:
+					slog.Debug("[httpAuth] Answering authentication challenge", "origin", challenge.Origin, "scheme", challenge.Scheme, "realm", challenge.Realm, "username", username, "sessionid", uuid)
+					response.Response = fetch.AuthChallengeResponseResponseProvideCredentials
+					response.Username = username
+					response.Password = password
+				}
+				go func() {
+					ectx := cdp.WithExecutor(lctx, c.Target)
+					if err := fetch.ContinueWithAuth(ev.RequestID, response).Do(ectx); err != nil {
+						slog.Debug("[httpAuth] Cannot answer authentication challenge", "error", err.Error(), "sessionid", uuid)
+					}
+				}()
+			}
+		})
+		// Only the requests of the allowed origins are paused, so challenges of other origins are not even seen
+		patterns := append(guard.fetchPatterns(), requestFilterPatterns(filters)...)
+		if err := enableResponseFilters(ctx, filters); err != nil {
+			return err
+		}
+		return fetch.Enable().WithHandleAuthRequests(true).WithPatterns(patterns).Do(ctx)
+	})
+}
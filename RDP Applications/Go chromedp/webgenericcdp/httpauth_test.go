@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/security"
+)
+
+func TestHTTPAuthWithheld(t *testing.T) {
+	target, _ := url.Parse("https://app.example.com/login")
+	guard, err := newOriginGuard(target, []string{"http://legacy.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard.states["https://app.example.com"] = security.StateSecure
+
+	for _, tc := range []struct {
+		origin string
+		scheme string
+		reason string
+	}{
+		{"https://app.example.com", "Basic", ""},
+		{"https://app.example.com", "NTLM", ""},
+		{"https://evil.example.com", "Basic", "origin is not allowed, allowed origins: https://app.example.com, http://legacy.example.com"},
+		{"http://legacy.example.com", "basic", "Basic authentication over http would send the password in clear text"},
+		{"http://legacy.example.com", "Digest", ""},
+	} {
+		if got := httpAuthWithheld(guard, &fetch.AuthChallenge{Origin: tc.origin, Scheme: tc.scheme}); got != tc.reason {
+			t.Errorf("%s %s: %q, want %q", tc.scheme, tc.origin, got, tc.reason)
+		}
+	}
+
+	// The TLS state is only checked once a document of the origin was loaded
+	guard, _ = newOriginGuard(target, []string{"https://app.example.com:8443"}, false)
+	if got := httpAuthWithheld(guard, &fetch.AuthChallenge{Origin: "https://app.example.com:8443", Scheme: "Basic"}); got != "" {
+		t.Errorf("unknown state: %q", got)
+	}
+	guard.states["https://app.example.com:8443"] = security.StateInsecureBroken
+	if got := httpAuthWithheld(guard, &fetch.AuthChallenge{Origin: "https://app.example.com:8443", Scheme: "Basic"}); !strings.Contains(got, "TLS state of the origin is insecure-broken") {
+		t.Errorf("insecure state: %q", got)
+	}
+	guard.insecure = true
+	if got := httpAuthWithheld(guard, &fetch.AuthChallenge{Origin: "https://app.example.com:8443", Scheme: "Basic"}); got != "" {
+		t.Errorf("browser_insecure: %q", got)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// keyNames maps the key names usable after kb. in loginActions to the keys of the chromedp kb package
+var keyNames = map[string]string{
+	"Backspace":            kb.Backspace,
+	"Tab":                  kb.Tab,
+	"Enter":                kb.Enter,
+	"Escape":               kb.Escape,
+	"Quote":                kb.Quote,
+	"Backslash":            kb.Backslash,
+	"Delete":               kb.Delete,
+	"Alt":                  kb.Alt,
+	"CapsLock":             kb.CapsLock,
+	"Control":              kb.Control,
+	"Fn":                   kb.Fn,
+	"FnLock":               kb.FnLock,
+	"Hyper":                kb.Hyper,
+	"Meta":                 kb.Meta,
+	"NumLock":              kb.NumLock,
+	"ScrollLock":           kb.ScrollLock,
+	"Shift":                kb.Shift,
+	"Super":                kb.Super,
+	"ArrowDown":            kb.ArrowDown,
+	"ArrowLeft":            kb.ArrowLeft,
+	"ArrowRight":           kb.ArrowRight,
+	"ArrowUp":              kb.ArrowUp,
+	"End":                  kb.End,
+	"Home":                 kb.Home,
+	"PageDown":             kb.PageDown,
+	"PageUp":               kb.PageUp,
+	"Clear":                kb.Clear,
+	"Copy":                 kb.Copy,
+	"Cut":                  kb.Cut,
+	"Insert":               kb.Insert,
+	"Paste":                kb.Paste,
+	"Redo":                 kb.Redo,
+	"Undo":                 kb.Undo,
+	"Again":                kb.Again,
+	"Cancel":               kb.Cancel,
+	"ContextMenu":          kb.ContextMenu,
+	"Find":                 kb.Find,
+	"Help":                 kb.Help,
+	"Pause":                kb.Pause,
+	"Props":                kb.Props,
+	"Select":               kb.Select,
+	"ZoomIn":               kb.ZoomIn,
+	"ZoomOut":              kb.ZoomOut,
+	"BrightnessDown":       kb.BrightnessDown,
+	"BrightnessUp":         kb.BrightnessUp,
+	"Eject":                kb.Eject,
+	"LogOff":               kb.LogOff,
+	"Power":                kb.Power,
+	"PrintScreen":          kb.PrintScreen,
+	"WakeUp":               kb.WakeUp,
+	"Convert":              kb.Convert,
+	"ModeChange":           kb.ModeChange,
+	"NonConvert":           kb.NonConvert,
+	"HangulMode":           kb.HangulMode,
+	"HanjaMode":            kb.HanjaMode,
+	"Hiragana":             kb.Hiragana,
+	"KanaMode":             kb.KanaMode,
+	"Katakana":             kb.Katakana,
+	"ZenkakuHankaku":       kb.ZenkakuHankaku,
+	"F1":                   kb.F1,
+	"F2":                   kb.F2,
+	"F3":                   kb.F3,
+	"F4":                   kb.F4,
+	"F5":                   kb.F5,
+	"F6":                   kb.F6,
+	"F7":                   kb.F7,
+	"F8":                   kb.F8,
+	"F9":                   kb.F9,
+	"F10":                  kb.F10,
+	"F11":                  kb.F11,
+	"F12":                  kb.F12,
+	"F13":                  kb.F13,
+	"F14":                  kb.F14,
+	"F15":                  kb.F15,
+	"F16":                  kb.F16,
+	"F17":                  kb.F17,
+	"F18":                  kb.F18,
+	"F19":                  kb.F19,
+	"F20":                  kb.F20,
+	"F21":                  kb.F21,
+	"F22":                  kb.F22,
+	"F23":                  kb.F23,
+	"F24":                  kb.F24,
+	"Close":                kb.Close,
+	"MailForward":          kb.MailForward,
+	"MailReply":            kb.MailReply,
+	"MailSend":             kb.MailSend,
+	"MediaPlayPause":       kb.MediaPlayPause,
+	"MediaStop":            kb.MediaStop,
+	"MediaTrackNext":       kb.MediaTrackNext,
+	"MediaTrackPrevious":   kb.MediaTrackPrevious,
+	"New":                  kb.New,
+	"Open":                 kb.Open,
+	"Print":                kb.Print,
+	"Save":                 kb.Save,
+	"SpellCheck":           kb.SpellCheck,
+	"AudioVolumeDown":      kb.AudioVolumeDown,
+	"AudioVolumeUp":        kb.AudioVolumeUp,
+	"AudioVolumeMute":      kb.AudioVolumeMute,
+	"LaunchApplication2":   kb.LaunchApplication2,
+	"LaunchCalendar":       kb.LaunchCalendar,
+	"LaunchMail":           kb.LaunchMail,
+	"LaunchMediaPlayer":    kb.LaunchMediaPlayer,
+	"LaunchMusicPlayer":    kb.LaunchMusicPlayer,
+	"LaunchApplication1":   kb.LaunchApplication1,
+	"LaunchScreenSaver":    kb.LaunchScreenSaver,
+	"LaunchSpreadsheet":    kb.LaunchSpreadsheet,
+	"LaunchWebBrowser":     kb.LaunchWebBrowser,
+	"LaunchContacts":       kb.LaunchContacts,
+	"LaunchPhone":          kb.LaunchPhone,
+	"LaunchAssistant":      kb.LaunchAssistant,
+	"BrowserBack":          kb.BrowserBack,
+	"BrowserFavorites":     kb.BrowserFavorites,
+	"BrowserForward":       kb.BrowserForward,
+	"BrowserHome":          kb.BrowserHome,
+	"BrowserRefresh":       kb.BrowserRefresh,
+	"BrowserSearch":        kb.BrowserSearch,
+	"BrowserStop":          kb.BrowserStop,
+	"ChannelDown":          kb.ChannelDown,
+	"ChannelUp":            kb.ChannelUp,
+	"ClosedCaptionToggle":  kb.ClosedCaptionToggle,
+	"Exit":                 kb.Exit,
+	"Guide":                kb.Guide,
+	"Info":                 kb.Info,
+	"MediaFastForward":     kb.MediaFastForward,
+	"MediaLast":            kb.MediaLast,
+	"MediaPause":           kb.MediaPause,
+	"MediaPlay":            kb.MediaPlay,
+	"MediaRecord":          kb.MediaRecord,
+	"MediaRewind":          kb.MediaRewind,
+	"Settings":             kb.Settings,
+	"ZoomToggle":           kb.ZoomToggle,
+	"AudioBassBoostToggle": kb.AudioBassBoostToggle,
+	"SpeechInputToggle":    kb.SpeechInputToggle,
+	"AppSwitch":            kb.AppSwitch,
+
+	// Printable keys which are hard to write in loginActions
+	"Space": " ",
+	"Comma": ",",
+	"Plus":  "+",
+}
+
+// keyModifierNames maps the modifier names usable in key chords like kb.Ctrl+A
+var keyModifierNames = map[string]input.Modifier{
+	"Ctrl":    input.ModifierCtrl,
+	"Control": input.ModifierCtrl,
+	"Shift":   input.ModifierShift,
+	"Alt":     input.ModifierAlt,
+	"Meta":    input.ModifierMeta,
+	"Cmd":     input.ModifierMeta,
+}
+
+// keyStroke is a key pressed together with modifiers, like kb.Shift+Tab
+type keyStroke struct {
+	name      string // As written in the configuration
+	key       rune
+	modifiers input.Modifier
+}
+
+// parseKeys parses a keyboard value of loginActions: a comma separated sequence of keys or chords,
+// each optionally prefixed with kb., like kb.Tab or kb.Ctrl+A,kb.Delete or kb.Shift+Tab,kb.Enter.
+// A key is a name from keyNames or a single character.
+func parseKeys(text string) ([]keyStroke, error) {
+	var strokes []keyStroke
+	for _, chord := range strings.Split(text, ",") {
+		name := strings.TrimPrefix(strings.TrimSpace(chord), "kb.")
+		if name == "" {
+			return nil, fmt.Errorf("empty key in %q", text)
+		}
+		stroke := keyStroke{name: name}
+		parts := strings.Split(name, "+")
+		// A chord ending with + (like Ctrl++) presses the + key
+		if strings.HasSuffix(name, "++") {
+			parts = append(parts[:len(parts)-2], "+")
+		}
+		for _, modifier := range parts[:len(parts)-1] {
+			m, ok := keyModifierNames[modifier]
+			if !ok {
+				return nil, fmt.Errorf("unknown modifier %q in %q, supported modifiers: Ctrl, Shift, Alt, Meta", modifier, chord)
+			}
+			stroke.modifiers |= m
+		}
+		key := parts[len(parts)-1]
+		if k, ok := keyNames[key]; ok {
+			stroke.key, _ = utf8.DecodeRuneInString(k)
+		} else if r, size := utf8.DecodeRuneInString(key); size == len(key) && r != utf8.RuneError {
+			stroke.key = r
+			// Ctrl+A means the A key, not the capital letter
+			if stroke.modifiers&^input.ModifierShift != 0 {
+				stroke.key = unicode.ToLower(r)
+			}
+		} else {
+			return nil, fmt.Errorf("key not supported: %q, use a key name of the chromedp kb package (like Tab, Escape, ArrowDown, F5) or a single character", key)
+		}
+		strokes = append(strokes, stroke)
+	}
+	return strokes, nil
+}
+
+// keyStrokesString returns the key sequence in the format of the configuration
+func keyStrokesString(strokes []keyStroke) string {
+	names := make([]string, len(strokes))
+	for i, stroke := range strokes {
+		names[i] = "kb." + stroke.name
+	}
+	return strings.Join(names, ",")
+}
+
+// pressKeys focuses the element of selector and presses the keys. Chords with modifiers other than Shift
+// are sent without text, so that the browser handles them as shortcuts (like Ctrl+A) instead of typing.
+func pressKeys(sel elementSelector, strokes []keyStroke) chromedp.Action {
+	return chromedp.Tasks{
+		chromedp.Focus(sel.query, sel.option, chromedp.NodeVisible),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			for _, stroke := range strokes {
+				shortcut := stroke.modifiers&^input.ModifierShift != 0
+				for _, event := range kb.Encode(stroke.key) {
+					event.Modifiers |= stroke.modifiers
+					if shortcut {
+						if event.Type == input.KeyChar {
+							continue
+						}
+						if event.Type == input.KeyDown {
+							event.Type = input.KeyRawDown
+						}
+						event.Text, event.UnmodifiedText = "", ""
+					}
+					if err := event.Do(ctx); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+func TestParseKeys(t *testing.T) {
+	for _, tc := range []struct {
+		text      string
+		keys      []rune
+		modifiers []input.Modifier
+		string    string // keyStrokesString of the strokes
+	}{
+		{text: "kb.Tab", keys: []rune{'\t'}, modifiers: []input.Modifier{0}, string: "kb.Tab"},
+		{text: "kb.Ctrl+A,kb.Delete", keys: []rune{'a', '\u007f'}, modifiers: []input.Modifier{input.ModifierCtrl, 0}, string: "kb.Ctrl+A,kb.Delete"},
+		{text: "kb.Shift+Tab, kb.Enter", keys: []rune{'\t', '\r'}, modifiers: []input.Modifier{input.ModifierShift, 0}, string: "kb.Shift+Tab,kb.Enter"},
+		{text: "Control+Shift+Z", keys: []rune{'z'}, modifiers: []input.Modifier{input.ModifierCtrl | input.ModifierShift}, string: "kb.Control+Shift+Z"},
+		// Shift alone types the character as written
+		{text: "Shift+A", keys: []rune{'A'}, modifiers: []input.Modifier{input.ModifierShift}, string: "kb.Shift+A"},
+		{text: "kb.Ctrl++", keys: []rune{'+'}, modifiers: []input.Modifier{input.ModifierCtrl}, string: "kb.Ctrl++"},
+		{text: "kb.Cmd+Comma", keys: []rune{','}, modifiers: []input.Modifier{input.ModifierMeta}, string: "kb.Cmd+Comma"},
+		{text: "kb.Alt+F4", keys: []rune{'\u0804'}, modifiers: []input.Modifier{input.ModifierAlt}, string: "kb.Alt+F4"},
+		{text: "kb.Space,x,é", keys: []rune{' ', 'x', 'é'}, modifiers: []input.Modifier{0, 0, 0}, string: "kb.Space,kb.x,kb.é"},
+	} {
+		strokes, err := parseKeys(tc.text)
+		if err != nil {
+			t.Errorf("%s: %v", tc.text, err)
+			continue
+		}
+		if len(strokes) != len(tc.keys) {
+			t.Errorf("%s: %d strokes, want %d", tc.text, len(strokes), len(tc.keys))
+			continue
+		}
+		for i, stroke := range strokes {
+			if stroke.key != tc.keys[i] || stroke.modifiers != tc.modifiers[i] {
+				t.Errorf("%s: stroke %d %q modifiers %d, want %q modifiers %d", tc.text, i, stroke.key, stroke.modifiers, tc.keys[i], tc.modifiers[i])
+			}
+		}
+		if got := keyStrokesString(strokes); got != tc.string {
+			t.Errorf("%s: string %s, want %s", tc.text, got, tc.string)
+		}
+	}
+}
+
+func TestParseKeysErrors(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		err  string
+	}{
+		{"", `empty key in ""`},
+		{"kb.Tab,,kb.Enter", `empty key in "kb.Tab,,kb.Enter"`},
+		{"kb.", `empty key in "kb."`},
+		{"kb.Hyper+A", `unknown modifier "Hyper" in "kb.Hyper+A", supported modifiers: Ctrl, Shift, Alt, Meta`},
+		{"kb.Ctrl+", `key not supported: ""`},
+		{"kb.Nope", `key not supported: "Nope", use a key name of the chromedp kb package`},
+		{"kb.Ctrl+AB", `key not supported: "AB"`},
+	} {
+		_, err := parseKeys(tc.text)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%q: error %v, want %q", tc.text, err, tc.err)
+		}
+	}
+}
+
+func TestPressKeys(t *testing.T) {
+	launch, _ := testBrowserLaunch(t)
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	sel := elementSelector{query: "#f", option: chromedp.ByQuery}
+	for _, tc := range []struct {
+		keys  string
+		value string
+	}{
+		{keys: "kb.End,a,b,kb.Backspace,kb.Shift+C", value: "helloaC"},
+		// Ctrl+A selects the text instead of typing a, the next key replaces it
+		{keys: "kb.Ctrl+A,x", value: "x"},
+		{keys: "kb.Home,kb.Delete,kb.Space,kb.Plus", value: " +ello"},
+	} {
+		strokes, err := parseKeys(tc.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value string
+		if err := chromedp.Run(ctx,
+			chromedp.Navigate(`data:text/html,<input id="f" value="hello">`),
+			pressKeys(sel, strokes),
+			chromedp.Value(sel.query, &value, sel.option),
+		); err != nil {
+			t.Fatal(err)
+		}
+		if value != tc.value {
+			t.Errorf("%s: value %q, want %q", tc.keys, value, tc.value)
+		}
+	}
+}
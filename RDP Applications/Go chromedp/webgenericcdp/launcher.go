@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Limits of reading the payload of OI-SG-RemoteApp-Launcher from STDIN
+const (
+	launcherStdinMaxSize = 1024 * 1024
+	launcherStdinTimeout = 30 * time.Second
+)
+
+// launcherPayload is the JSON object received from OI-SG-RemoteApp-Launcher on STDIN, like
+// {"username":"jdoe","password":"...","cli_args":"C:\\RApp\\webapp.conf -debug","Target.AssetNetworkAddress":"10.0.0.1",...}
+type launcherPayload struct {
+	Username string
+	Password string
+	CliArgs  string
+	Target   launcherTarget
+
+	received map[string]bool        // Keys of the typed fields present in the payload
+	other    map[string]interface{} // Fields without a typed representation, like Target.Custom, by their key
+	raw      []byte
+}
+
+// launcherTarget are the Target.* fields of the payload webgenericcdp knows about
+type launcherTarget struct {
+	AssetName           string
+	AssetNetworkAddress string
+	AccountName         string
+	AccountDomainName   string
+	AccountPassword     string
+	Port                string // Sent as a number or a string
+	TotpCodes           string // JSON array of the codes, sent as an array or as a string containing one
+}
+
+// launcherField is a typed field of the payload
+type launcherField struct {
+	key   string
+	field func(p *launcherPayload) *string
+	kind  string // Accepted JSON type: string, number (or a string) or array (or a string containing one)
+}
+
+var launcherFields = []launcherField{
+	{"username", func(p *launcherPayload) *string { return &p.Username }, "string"},
+	{"password", func(p *launcherPayload) *string { return &p.Password }, "string"},
+	{"cli_args", func(p *launcherPayload) *string { return &p.CliArgs }, "string"},
+	{"Target.AssetName", func(p *launcherPayload) *string { return &p.Target.AssetName }, "string"},
+	{"Target.AssetNetworkAddress", func(p *launcherPayload) *string { return &p.Target.AssetNetworkAddress }, "string"},
+	{"Target.AccountName", func(p *launcherPayload) *string { return &p.Target.AccountName }, "string"},
+	{"Target.AccountDomainName", func(p *launcherPayload) *string { return &p.Target.AccountDomainName }, "string"},
+	{"Target.AccountPassword", func(p *launcherPayload) *string { return &p.Target.AccountPassword }, "string"},
+	{"Target.Port", func(p *launcherPayload) *string { return &p.Target.Port }, "number"},
+	{"Target.TotpCodes", func(p *launcherPayload) *string { return &p.Target.TotpCodes }, "array"},
+}
+
+// values returns every value of the payload by its key, used by the placeholders of the configuration.
+// The typed fields are taken from the payload, the other fields as they were received.
+func (p *launcherPayload) values() map[string]interface{} {
+	values := make(map[string]interface{}, len(p.other)+len(p.received))
+	for key, value := range p.other {
+		values[key] = value
+	}
+	for _, f := range launcherFields {
+		if p.received[f.key] {
+			values[f.key] = *f.field(p)
+		}
+	}
+	return values
+}
+
+// readLauncherPayload reads a single JSON object from r. The object may span multiple lines and may be preceded by other output,
+// it is read without waiting for the end of the input. Reading fails if the object is larger than maxSize or does not arrive within timeout.
+func readLauncherPayload(r io.Reader, maxSize int64, timeout time.Duration) (*launcherPayload, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		limited := &io.LimitedReader{R: r, N: maxSize + 1}
+		data, err := readJSONObject(bufio.NewReader(limited))
+		if err != nil && limited.N <= 0 {
+			data, err = nil, fmt.Errorf("payload on STDIN is larger than %d bytes", maxSize)
+		}
+		done <- result{data, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return decodeLauncherPayload(res.data)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no payload received on STDIN within %s, start webgenericcdp with the --use-stdin option of the launcher", timeout)
+	}
+}
+
+// readJSONObject skips the input until the first '{' and returns the JSON object starting there
+func readJSONObject(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return nil, errors.New("no JSON object received on STDIN")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read STDIN: %w", err)
+		}
+		if b == '{' {
+			r.UnreadByte()
+			break
+		}
+	}
+	var raw json.RawMessage
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("payload on STDIN is not valid JSON: %w", err)
+	}
+	return raw, nil
+}
+
+// decodeLauncherPayload parses and validates the payload. Numbers are kept as written, so that large integers are not turned into floats.
+func decodeLauncherPayload(data []byte) (*launcherPayload, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\ufeff"))
+	p := &launcherPayload{received: map[string]bool{}, raw: data}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&p.other); err != nil {
+		return nil, fmt.Errorf("payload is not a JSON object: %w", err)
+	}
+	if p.other == nil {
+		return nil, errors.New("payload is not a JSON object: null")
+	}
+
+	// Schema of the typed fields, a null value is treated like a missing field
+	var errs []error
+	for _, f := range launcherFields {
+		v, ok := p.other[f.key]
+		if !ok || v == nil {
+			continue
+		}
+		var text string
+		switch v := v.(type) {
+		case string:
+			text = v
+		case json.Number:
+			if f.kind != "number" {
+				errs = append(errs, fmt.Errorf("payload field %q must be a %s, got %s", f.key, launcherFieldType(f.kind), jsonType(v)))
+				continue
+			}
+			text = v.String()
+		case []interface{}:
+			if f.kind != "array" {
+				errs = append(errs, fmt.Errorf("payload field %q must be a %s, got %s", f.key, launcherFieldType(f.kind), jsonType(v)))
+				continue
+			}
+			// The codes are used as the JSON text, like when the launcher sends them as a string
+			encoded, _ := json.Marshal(v)
+			text = string(encoded)
+		default:
+			errs = append(errs, fmt.Errorf("payload field %q must be a %s, got %s", f.key, launcherFieldType(f.kind), jsonType(v)))
+			continue
+		}
+		var array []json.RawMessage
+		if f.kind == "array" && text != "" && json.Unmarshal([]byte(text), &array) != nil {
+			errs = append(errs, fmt.Errorf("payload field %q must be a %s, got a string which is no JSON array", f.key, launcherFieldType(f.kind)))
+			continue
+		}
+		*f.field(p) = text
+		p.received[f.key] = true
+		delete(p.other, f.key)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return p, nil
+}
+
+// launcherFieldType describes the accepted JSON types of a typed field for error messages
+func launcherFieldType(kind string) string {
+	switch kind {
+	case "number":
+		return "number or a string"
+	case "array":
+		return "JSON array or a string containing one"
+	default:
+		return "string"
+	}
+}
+
+// jsonType returns the JSON type name of a decoded value for error messages
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// cliArgs are the arguments of webgenericcdp given in --args of the launcher and received in cli_args
+type cliArgs struct {
+	configFile string
+	debug      bool
+	logLevel   string // Overrides the log level, debug|info|warn|error
+}
+
+// Accepted values of the -loglevel flag
+var cliLogLevels = map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+
+// parseCliArgs parses cli_args: the configuration file and flags, in any order, like
+// C:\RApp\webapp.conf -debug or -loglevel=info "C:\My Apps\webapp.conf". Double quotes group words, backslashes are kept
+// as they are (Windows paths). An unquoted path containing spaces is accepted as well, as in earlier versions: it is taken
+// as written, with its original spacing, and must not be interrupted by flags.
+func parseCliArgs(s string) (cliArgs, error) {
+	var args cliArgs
+	flags := flag.NewFlagSet("webgenericcdp", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&args.debug, "debug", false, "debug logging")
+	flags.StringVar(&args.logLevel, "loglevel", "", "log level: debug|info|warn|error")
+
+	words, err := splitCliArgs(s)
+	if err != nil {
+		return args, err
+	}
+	texts := make([]string, len(words))
+	for i, word := range words {
+		texts[i] = word.text
+	}
+	// Indexes of the words which are not flags
+	var positional []int
+	for rest := texts; len(rest) > 0; {
+		if err := flags.Parse(rest); err != nil {
+			return args, fmt.Errorf("invalid cli_args %q: %w", s, err)
+		}
+		rest = flags.Args()
+		if len(rest) > 0 {
+			positional = append(positional, len(texts)-len(rest))
+			rest = rest[1:]
+		}
+	}
+	if len(positional) == 0 {
+		return args, fmt.Errorf("invalid cli_args %q: missing configuration file", s)
+	}
+	first, last := words[positional[0]], words[positional[len(positional)-1]]
+	switch {
+	case len(positional) == 1:
+		args.configFile = first.text
+	case positional[len(positional)-1]-positional[0] != len(positional)-1:
+		return args, fmt.Errorf("invalid cli_args %q: the configuration file path is interrupted by flags, enclose a path with spaces in double quotes", s)
+	case strings.Contains(s[first.start:last.end], `"`):
+		return args, fmt.Errorf("invalid cli_args %q: only a part of the configuration file path is quoted, enclose the whole path in double quotes", s)
+	default:
+		// An unquoted path with spaces, taken as written
+		args.configFile = s[first.start:last.end]
+	}
+	if args.logLevel != "" {
+		if _, ok := cliLogLevels[args.logLevel]; !ok {
+			return args, fmt.Errorf("invalid cli_args %q: -loglevel must be debug, info, warn or error", s)
+		}
+	}
+	return args, nil
+}
+
+// cliWord is a word of cli_args without its quotes, start and end are its byte offsets in cli_args as written
+type cliWord struct {
+	text       string
+	start, end int
+}
+
+// splitCliArgs splits s into words at spaces outside of double quotes
+func splitCliArgs(s string) ([]cliWord, error) {
+	var words []cliWord
+	var word strings.Builder
+	start, inWord, quoted := 0, false, false
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			if !inWord {
+				start, inWord = i, true
+			}
+		case (c == ' ' || c == '\t') && !quoted:
+			if inWord {
+				words = append(words, cliWord{text: word.String(), start: start, end: i})
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			if !inWord {
+				start, inWord = i, true
+			}
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid cli_args %q: unterminated quote", s)
+	}
+	if inWord {
+		words = append(words, cliWord{text: word.String(), start: start, end: len(s)})
+	}
+	return words, nil
+}
+
+// level returns the log level requested by the flags
+func (a cliArgs) level() slog.Level {
+	if a.logLevel != "" {
+		return cliLogLevels[a.logLevel]
+	}
+	if a.debug {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeLauncherPayload(t *testing.T) {
+	p, err := decodeLauncherPayload([]byte("\ufeff" + `{"username":"jdoe","password":"pw","cli_args":"C:\\RApp\\webapp.conf -debug",
+		"Target.AssetNetworkAddress":"10.0.0.1","Target.Port":8443,"Target.AccountDomainName":null,
+		"Target.TotpCodes":[{"Code":"123456","UnixTime":1700000000,"Period":30}],"Target.Custom":{"tenantId":"contoso"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Username != "jdoe" || p.Password != "pw" || p.CliArgs != `C:\RApp\webapp.conf -debug` {
+		t.Errorf("typed fields: %+v", p)
+	}
+	want := launcherTarget{AssetNetworkAddress: "10.0.0.1", Port: "8443", TotpCodes: `[{"Code":"123456","Period":30,"UnixTime":1700000000}]`}
+	if p.Target != want {
+		t.Errorf("Target = %+v, want %+v", p.Target, want)
+	}
+
+	values := p.values()
+	for key, want := range map[string]string{"username": "jdoe", "password": "pw", "Target.Port": "8443", "Target.TotpCodes": want.TotpCodes} {
+		if got, _ := stdinValue(values, key); got != want {
+			t.Errorf("value of %s = %q, want %q", key, got, want)
+		}
+	}
+	if got, _ := stdinValue(values, "Target.Custom.tenantId"); got != "contoso" {
+		t.Errorf("untyped field: %q", got)
+	}
+	if _, ok := values["Target.AccountName"]; ok {
+		t.Error("missing typed field added to the values")
+	}
+	if v, ok := values["Target.AccountDomainName"]; !ok || v != nil {
+		t.Errorf("null field: %v, %v", v, ok)
+	}
+
+	// The typed field is the source of the placeholders
+	p.Password = "changed"
+	if got, _ := stdinValue(p.values(), "password"); got != "changed" {
+		t.Errorf("password placeholder not taken from the typed field: %q", got)
+	}
+}
+
+func TestDecodeLauncherPayloadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		errs    []string
+	}{
+		{`[1]`, []string{"payload is not a JSON object"}},
+		{`null`, []string{"payload is not a JSON object: null"}},
+		{`{"username":1}`, []string{`payload field "username" must be a string, got number`}},
+		{`{"password":true,"cli_args":["x"]}`, []string{`"password" must be a string, got boolean`, `"cli_args" must be a string, got array`}},
+		{`{"Target.Port":{}}`, []string{`"Target.Port" must be a number or a string, got object`}},
+		{`{"Target.TotpCodes":5}`, []string{`"Target.TotpCodes" must be a JSON array or a string containing one, got number`}},
+		{`{"Target.TotpCodes":"123456"}`, []string{`"Target.TotpCodes" must be a JSON array or a string containing one, got a string which is no JSON array`}},
+	} {
+		_, err := decodeLauncherPayload([]byte(tc.payload))
+		if err == nil {
+			t.Errorf("%s: no error", tc.payload)
+			continue
+		}
+		for _, want := range tc.errs {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not contain %q", tc.payload, err, want)
+			}
+		}
+	}
+	if _, err := decodeLauncherPayload([]byte(`{"Target.TotpCodes":"[]","Target.Port":"443"}`)); err != nil {
+		t.Errorf("fields sent as strings: %v", err)
+	}
+}
+
+func TestReadLauncherPayload(t *testing.T) {
+	p, err := readLauncherPayload(strings.NewReader("launcher output\n{\n \"username\": \"jdoe\",\n \"cli_args\": \"a.conf\"\n}\ntrailing"), 1024, time.Second)
+	if err != nil || p.Username != "jdoe" || p.CliArgs != "a.conf" {
+		t.Errorf("multi-line payload: %+v, %v", p, err)
+	}
+	for _, tc := range []struct {
+		input string
+		err   string
+	}{
+		{"", "no JSON object received on STDIN"},
+		{`{"username":`, "payload on STDIN is not valid JSON"},
+		{`{"username":"` + strings.Repeat("x", 64) + `"}`, "payload on STDIN is larger than 32 bytes"},
+	} {
+		if _, err := readLauncherPayload(strings.NewReader(tc.input), 32, time.Second); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: error %v, want %q", tc.input, err, tc.err)
+		}
+	}
+}
+
+func TestReadLauncherPayloadTimeout(t *testing.T) {
+	r, w := newBlockingReader()
+	defer w()
+	if _, err := readLauncherPayload(r, 1024, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "no payload received on STDIN within") {
+		t.Errorf("error %v", err)
+	}
+}
+
+// newBlockingReader returns a reader which blocks until the returned function is called
+func newBlockingReader() (*blockingReader, func()) {
+	r := &blockingReader{release: make(chan struct{})}
+	return r, func() { close(r.release) }
+}
+
+type blockingReader struct {
+	release chan struct{}
+}
+
+func (r *blockingReader) Read([]byte) (int, error) {
+	<-r.release
+	return 0, nil
+}
+
+func TestParseCliArgs(t *testing.T) {
+	for _, tc := range []struct {
+		input      string
+		configFile string
+		debug      bool
+		logLevel   string
+		err        string
+	}{
+		{input: `C:\RApp\webapp.conf`, configFile: `C:\RApp\webapp.conf`},
+		{input: `C:\RApp\webapp.conf -debug`, configFile: `C:\RApp\webapp.conf`, debug: true},
+		{input: `-loglevel=warn "C:\My Apps\webapp.conf"`, configFile: `C:\My Apps\webapp.conf`, logLevel: "warn"},
+		{input: `C:\My Apps\webapp.conf`, configFile: `C:\My Apps\webapp.conf`},
+		// Unquoted paths keep their spacing
+		{input: "C:\\My  Apps\\web\tapp.conf -debug", configFile: "C:\\My  Apps\\web\tapp.conf", debug: true},
+		{input: ` -debug  C:\My Apps\webapp.conf  `, configFile: `C:\My Apps\webapp.conf`, debug: true},
+		{input: `C:\My -debug Apps\webapp.conf`, err: "the configuration file path is interrupted by flags"},
+		{input: `"C:\My Apps"\web app.conf`, err: "only a part of the configuration file path is quoted"},
+		{input: `"C:\My  Apps\webapp.conf"`, configFile: `C:\My  Apps\webapp.conf`},
+		{input: `-debug`, err: "missing configuration file"},
+		{input: `a.conf -loglevel=trace`, err: "-loglevel must be debug, info, warn or error"},
+		{input: `"a.conf`, err: "unterminated quote"},
+		{input: `a.conf -unknown`, err: "flag provided but not defined"},
+	} {
+		args, err := parseCliArgs(tc.input)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.input, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.input, err)
+			continue
+		}
+		if args.configFile != tc.configFile || args.debug != tc.debug || args.logLevel != tc.logLevel {
+			t.Errorf("%s: %+v", tc.input, args)
+		}
+	}
+}
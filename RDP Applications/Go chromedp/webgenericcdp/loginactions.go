@@ -0,0 +1,646 @@
+package main
+
+// Parser of the loginActions setting.
+//
+// Grammar:
+//
+//	loginActions = action { "||" action }
+//	action       = verb [ "(" option { "," option } ")" ] { "::" field }   (options: optional, within, timeout)
+//	option       = name [ "=" value ]
+//	field        = quoted | bare
+//	quoted       = "`" { any character except "`" | "``" } "`"
+//	bare         = { escape | character }
+//
+// Rules of the bare fields:
+//   - In the verb and the selector (the first two fields) "::" and "||" are not separators inside [...] and (...),
+//     nor inside '...' and "..." strings within those brackets, so CSS selectors like a[href*='::'] and XPath
+//     expressions like //a[text()='A||B'] need no escaping. A backslash there protects the next character from
+//     being a separator or bracket, and both characters are kept as they are (CSS and XPath handle their own escapes).
+//   - In the other fields \: \| and \\ stand for : | and \, any other backslash is kept as it is
+//     (so down-level logon names like DOMAIN\{username} keep working).
+//   - A field enclosed in backticks is taken literally, `` stands for a single backtick.
+//     Use it for pseudo-elements like `a::after` or for values containing "::" or "||".
+//
+// Blocks group actions which are performed only if certain elements appear on the page:
+//
+//	if(within=<duration>)::<selector>||<actions>||[else||<actions>||]end
+//	first(within=<duration>,optional)||case::<selector>||<actions>||{case::<selector>||<actions>||}[else||<actions>||]end
+//
+// Wait actions sequence redirect-heavy flows without slowing every step down:
+//
+//	wait-visible::<selector>  wait-gone::<selector>  wait-url::<regex>  wait-title::<regex>  sleep::<duration>
+//
+// The grammar is a superset of the original "split on || then on ::" syntax, which is also the syntax of the AutoIt web_generic script.
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type actionKind string
+
+const (
+	actionClick  actionKind = "c"     // c::<selector>
+	actionValue  actionKind = "v"     // v::<selector>::<value>
+	actionSecret actionKind = "s"     // s::<selector>::<secret-from-Safeguard>
+	actionOTP    actionKind = "o"     // o::<selector>::<totp-info-from-Safeguard>::<optional--min-seconds-before-expiry>
+	actionIf     actionKind = "if"    // if::<selector>||<actions>||[else||<actions>||]end
+	actionFirst  actionKind = "first" // first||case::<selector>||<actions>||...||end
+
+	// Wait actions, they do not wait for an element before they are performed
+	actionWaitVisible actionKind = "wait-visible" // wait-visible::<selector>
+	actionWaitGone    actionKind = "wait-gone"    // wait-gone::<selector>
+	actionWaitURL     actionKind = "wait-url"     // wait-url::<regex>
+	actionWaitTitle   actionKind = "wait-title"   // wait-title::<regex>
+	actionSleep       actionKind = "sleep"        // sleep::<duration>
+
+	// Keywords closing or separating the branches of blocks, they never appear in a parsed action list
+	actionCase actionKind = "case"
+	actionElse actionKind = "else"
+	actionEnd  actionKind = "end"
+)
+
+// Wait period of the optional option and of the if block when it is not configured
+const defaultOptionalWait = 5 * time.Second
+
+type valueKind int
+
+const (
+	valueStatic    valueKind = iota // Static string from the configuration
+	valueSafeguard                  // Template with values received from Safeguard via STDIN, {key} in the configuration
+	valueKeyboard                   // Keyboard key, kb.<key> in the configuration
+)
+
+// valueField is the value field of an action
+type valueField struct {
+	kind     valueKind
+	text     string         // The value as written in the configuration
+	template *valueTemplate // Parsed template
+	keys     []keyStroke    // Parsed keyboard keys
+	column   int
+}
+
+// actionBranch is a branch of an if or first block: its actions are performed if the selector appears
+type actionBranch struct {
+	selector       string
+	selectorColumn int
+	actions        []loginAction
+}
+
+// loginAction is a single parsed entry of loginActions
+type loginAction struct {
+	kind                   actionKind
+	selector               string // Selector, regex of wait-url and wait-title, or duration of sleep
+	selectorColumn         int
+	pattern                *regexp.Regexp // wait-url and wait-title actions
+	sleep                  time.Duration  // sleep action
+	value                  valueField     // v, s and o actions
+	minSecondsBeforeExpiry int            // o action
+	otp                    otpSettings    // o action
+	optional               time.Duration  // c, v, s and o actions: skip the action if its element does not appear within this period. 0 if mandatory
+	within                 time.Duration  // if and first blocks: how long to wait for the selectors. 0 waits without limit
+	timeout                time.Duration  // Overrides actionTimeout for this action. 0 if not configured
+	branches               []actionBranch // if (single branch) and first blocks
+	otherwise              []loginAction  // else branch of if and first blocks
+	column                 int            // Column of the action within loginActions
+	source                 string         // The action as written in the configuration
+}
+
+func (a loginAction) String() string {
+	return fmt.Sprintf("action at column %d (%s)", a.column, a.source)
+}
+
+// actionParseError is a problem found in loginActions. Column is 1-based, counted in characters within the loginActions value.
+type actionParseError struct {
+	column int
+	msg    string
+}
+
+func (e *actionParseError) Error() string {
+	return "column " + strconv.Itoa(e.column) + ": " + e.msg
+}
+
+// actionParseErrors lists every problem found in loginActions
+type actionParseErrors []*actionParseError
+
+func (e actionParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e actionParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// actionField is a token of an action: the verb or one of the fields following it
+type actionField struct {
+	text   string
+	column int
+}
+
+// actionOption is an option of the verb, like optional=3000 in c(optional=3000)::#KmsiCheckbox
+type actionOption struct {
+	name   string
+	value  string
+	set    bool // Whether a value was given after '='
+	column int
+}
+
+// rawAction is an action split into fields, before interpreting the verb
+type rawAction struct {
+	fields []actionField
+	column int
+	source string
+}
+
+// parseLoginActions parses the loginActions setting into a typed action list, with blocks nested into their parent.
+// Errors are collected for all actions, the returned error is an actionParseErrors.
+func parseLoginActions(src string) ([]loginAction, error) {
+	raws, errs := tokenizeLoginActions(src)
+	var flat []loginAction
+	for _, raw := range raws {
+		action, err := parseAction(raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flat = append(flat, action)
+	}
+	if len(errs) > 0 {
+		return flat, errs
+	}
+	n := &actionNester{flat: flat}
+	actions := n.list()
+	for n.pos < len(n.flat) {
+		// list() stops only at keywords closing a block
+		a := n.flat[n.pos]
+		n.errs = append(n.errs, &actionParseError{column: a.column, msg: fmt.Sprintf("%s without a matching if or first", a.kind)})
+		n.pos++
+		n.list()
+	}
+	if len(n.errs) > 0 {
+		return actions, n.errs
+	}
+	return actions, nil
+}
+
+// actionNester builds the nested action list of blocks from the flat list of parsed actions
+type actionNester struct {
+	flat []loginAction
+	pos  int
+	errs actionParseErrors
+}
+
+// list returns the actions until the end of the input or until a case, else or end keyword
+func (n *actionNester) list() []loginAction {
+	var actions []loginAction
+	for n.pos < len(n.flat) {
+		a := n.flat[n.pos]
+		switch a.kind {
+		case actionCase, actionElse, actionEnd:
+			return actions
+		case actionIf:
+			n.pos++
+			a.branches[0].actions = n.list()
+			n.blockEnd(&a)
+		case actionFirst:
+			n.pos++
+			if n.pos < len(n.flat) && n.flat[n.pos].kind != actionCase {
+				n.errs = append(n.errs, &actionParseError{column: n.flat[n.pos].column, msg: "first must be followed by case::<selector>"})
+				n.list()
+			}
+			for n.pos < len(n.flat) && n.flat[n.pos].kind == actionCase {
+				c := n.flat[n.pos]
+				n.pos++
+				a.branches = append(a.branches, actionBranch{selector: c.selector, selectorColumn: c.selectorColumn, actions: n.list()})
+			}
+			n.blockEnd(&a)
+		default:
+			n.pos++
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+// blockEnd reads the optional else branch and the end keyword of the block a
+func (n *actionNester) blockEnd(a *loginAction) {
+	if n.pos < len(n.flat) && n.flat[n.pos].kind == actionElse {
+		n.pos++
+		a.otherwise = n.list()
+	}
+	if n.pos >= len(n.flat) {
+		n.errs = append(n.errs, &actionParseError{column: a.column, msg: fmt.Sprintf("%s block is not closed with end", a.kind)})
+		return
+	}
+	if n.flat[n.pos].kind != actionEnd {
+		n.errs = append(n.errs, &actionParseError{column: n.flat[n.pos].column, msg: fmt.Sprintf("unexpected %s in %s block started at column %d, expected end", n.flat[n.pos].kind, a.kind, a.column)})
+	}
+	n.pos++
+}
+
+func parseAction(raw rawAction) (loginAction, *actionParseError) {
+	verb := raw.fields[0]
+	name, options, err := parseVerb(verb)
+	if err != nil {
+		return loginAction{column: raw.column, source: raw.source}, err
+	}
+	action := loginAction{kind: actionKind(name), column: raw.column, source: raw.source}
+	fieldErr := func(f actionField, format string, args ...any) *actionParseError {
+		return &actionParseError{column: f.column, msg: fmt.Sprintf(format, args...)}
+	}
+	countErr := func(format string) *actionParseError {
+		return fieldErr(verb, "%s action with improper number of configuration items. Format: %s", name, format)
+	}
+
+	var formatHelp string
+	var allowedOptions []string
+	switch action.kind {
+	case actionClick:
+		formatHelp = "c::<selector>"
+		allowedOptions = []string{"optional", "timeout"}
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+	case actionValue:
+		formatHelp = "v::<selector>::<value>"
+		allowedOptions = []string{"optional", "timeout"}
+		if len(raw.fields) != 3 {
+			return action, countErr(formatHelp)
+		}
+	case actionSecret:
+		formatHelp = "s::<selector>::<secret-value>"
+		allowedOptions = []string{"optional", "timeout"}
+		if len(raw.fields) != 3 {
+			return action, countErr(formatHelp)
+		}
+	case actionOTP:
+		formatHelp = "o::<selector>::<totp-info-from-safeguard>::<optional--min-seconds-before-expiry>"
+		allowedOptions = []string{"optional", "timeout", "totp", "hotp", "digits", "period", "algorithm", "clock-offset", "counter"}
+		if len(raw.fields) != 3 && len(raw.fields) != 4 {
+			return action, countErr(formatHelp)
+		}
+	case actionWaitVisible, actionWaitGone:
+		formatHelp = string(action.kind) + "::<selector>"
+		allowedOptions = []string{"timeout"}
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+	case actionWaitURL, actionWaitTitle:
+		formatHelp = string(action.kind) + "::<regex>"
+		allowedOptions = []string{"timeout"}
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+	case actionSleep:
+		formatHelp = "sleep::<duration>"
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+	case actionIf:
+		formatHelp = "if(within=<duration>)::<selector>"
+		allowedOptions = []string{"within", "timeout"}
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+		action.within = defaultOptionalWait
+	case actionCase:
+		formatHelp = "case::<selector>"
+		if len(raw.fields) != 2 {
+			return action, countErr(formatHelp)
+		}
+	case actionFirst, actionElse, actionEnd:
+		formatHelp = string(action.kind)
+		if action.kind == actionFirst {
+			formatHelp = "first(within=<duration>,optional)"
+			allowedOptions = []string{"within", "optional", "timeout"}
+		}
+		if len(raw.fields) != 1 {
+			return action, countErr(formatHelp)
+		}
+	default:
+		return action, fieldErr(verb, "unknown action %q, supported actions: c, v, s, o, wait-visible, wait-gone, wait-url, wait-title, sleep, if, first", name)
+	}
+
+	for _, opt := range options {
+		if !slices.Contains(allowedOptions, opt.name) {
+			if len(allowedOptions) == 0 {
+				return action, &actionParseError{column: opt.column, msg: fmt.Sprintf("%s does not accept options", name)}
+			}
+			return action, &actionParseError{column: opt.column, msg: fmt.Sprintf("unknown option %q of %s, supported options: %s", opt.name, name, strings.Join(allowedOptions, ", "))}
+		}
+		switch {
+		case opt.name == "optional" && action.kind == actionFirst:
+			if opt.set {
+				return action, &actionParseError{column: opt.column, msg: "optional of first does not take a value, use within to set the wait period"}
+			}
+			action.optional = defaultOptionalWait
+		case opt.name == "optional":
+			action.optional = defaultOptionalWait
+			if opt.set {
+				d, err := parseActionDuration(opt.value)
+				if err != nil {
+					return action, &actionParseError{column: opt.column, msg: "optional: " + err.Error()}
+				}
+				action.optional = d
+			}
+		case opt.name == "within":
+			d, err := parseActionDuration(opt.value)
+			if err != nil {
+				return action, &actionParseError{column: opt.column, msg: "within: " + err.Error()}
+			}
+			action.within = d
+		case opt.name == "timeout":
+			d, err := parseActionDuration(opt.value)
+			if err != nil {
+				return action, &actionParseError{column: opt.column, msg: "timeout: " + err.Error()}
+			}
+			action.timeout = d
+		case action.kind == actionOTP:
+			if err := parseOTPOption(&action.otp, opt); err != nil {
+				return action, err
+			}
+		}
+	}
+	if action.kind == actionOTP {
+		if err := checkOTPOptions(&action.otp, options, len(raw.fields) == 4); err != nil {
+			return action, err
+		}
+	}
+
+	if action.kind == actionFirst && action.optional > 0 && action.within == 0 {
+		action.within = defaultOptionalWait
+	}
+
+	if len(raw.fields) < 2 {
+		return action, nil
+	}
+
+	if raw.fields[1].text == "" {
+		return action, fieldErr(verb, "missing selector. Format: %s", formatHelp)
+	}
+	action.selector = raw.fields[1].text
+	action.selectorColumn = raw.fields[1].column
+	switch action.kind {
+	case actionIf:
+		action.branches = []actionBranch{{selector: action.selector, selectorColumn: action.selectorColumn}}
+	case actionWaitURL, actionWaitTitle:
+		re, err := regexp.Compile(action.selector)
+		if err != nil {
+			return action, fieldErr(raw.fields[1], "invalid regular expression: %s", err)
+		}
+		action.pattern = re
+	case actionSleep:
+		d, err := parseActionDuration(action.selector)
+		if err != nil {
+			return action, fieldErr(raw.fields[1], "%s", err)
+		}
+		action.sleep = d
+	default:
+		if strategy, rest := splitSelectorStrategy(action.selector); strategy != "" && strings.TrimSpace(rest) == "" {
+			return action, fieldErr(raw.fields[1], "missing selector after %s:. Format: %s", strategy, formatHelp)
+		}
+	}
+
+	if len(raw.fields) >= 3 {
+		f := raw.fields[2]
+		action.value = valueField{text: f.text, column: f.column}
+		switch {
+		case action.kind == actionValue && strings.ContainsAny(f.text, "{}") && !isValueTemplate(f.text):
+			// Braces which do not form a valid template, like Pa{ss, are entered as written, as in earlier versions
+			action.value.kind = valueStatic
+		case strings.ContainsAny(f.text, "{}"):
+			// Template with values received from Safeguard via STDIN, like {username}@{Target.AccountDomainName},
+			// or static text with {{ and }} escapes, like a{{b}}c
+			action.value.kind = valueSafeguard
+			t, err := parseTemplate(f.text)
+			if err != nil {
+				return action, &actionParseError{column: f.column + err.column - 1, msg: err.msg}
+			}
+			action.value.template = t
+		case action.kind == actionSecret || action.kind == actionOTP:
+			// Secrets and TOTP info always come from Safeguard, the braces are optional
+			if f.text == "" {
+				return action, fieldErr(f, "missing Safeguard value name. Format: %s", formatHelp)
+			}
+			action.value.kind = valueSafeguard
+			action.value.template = keyTemplate(f.text)
+		case strings.HasPrefix(f.text, "kb."):
+			action.value.kind = valueKeyboard
+		default:
+			action.value.kind = valueStatic
+		}
+		if action.value.kind == valueKeyboard {
+			keys, err := parseKeys(f.text)
+			if err != nil {
+				return action, fieldErr(f, "%s", err)
+			}
+			action.value.keys = keys
+		}
+	}
+
+	if len(raw.fields) == 4 {
+		f := raw.fields[3]
+		seconds, err := strconv.Atoi(f.text)
+		if err != nil || seconds < 0 {
+			return action, fieldErr(f, "min-seconds-before-expiry must be a non-negative number, got %q", f.text)
+		}
+		action.minSecondsBeforeExpiry = seconds
+	}
+	return action, nil
+}
+
+// parseVerb splits the verb field into the action name and its options, like c(optional=3000)
+func parseVerb(verb actionField) (string, []actionOption, *actionParseError) {
+	name, rest, hasOptions := strings.Cut(verb.text, "(")
+	name = strings.TrimSpace(name)
+	if !hasOptions {
+		return name, nil, nil
+	}
+	if !strings.HasSuffix(rest, ")") {
+		return name, nil, &actionParseError{column: verb.column + utf8.RuneCountInString(verb.text), msg: "options of " + name + " must be closed with )"}
+	}
+	rest = strings.TrimSuffix(rest, ")")
+
+	var options []actionOption
+	column := verb.column + utf8.RuneCountInString(verb.text[:len(verb.text)-len(rest)-1])
+	for _, opt := range strings.Split(rest, ",") {
+		optColumn := column + utf8.RuneCountInString(opt) - utf8.RuneCountInString(strings.TrimLeft(opt, " "))
+		column += utf8.RuneCountInString(opt) + 1
+		key, value, set := strings.Cut(opt, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return name, nil, &actionParseError{column: optColumn, msg: "empty option of " + name}
+		}
+		options = append(options, actionOption{name: key, value: strings.TrimSpace(value), set: set, column: optColumn})
+	}
+	return name, options, nil
+}
+
+// parseActionDuration parses a wait period of an option: a number of milliseconds (like browserInputDelay) or a Go duration like 5s
+func parseActionDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(s); err == nil {
+		if ms <= 0 {
+			return 0, fmt.Errorf("%q must be a positive number of milliseconds or a duration like 5s", s)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q must be a positive number of milliseconds or a duration like 5s", s)
+	}
+	return d, nil
+}
+
+// tokenizeLoginActions splits loginActions into actions and fields according to the grammar above
+func tokenizeLoginActions(src string) ([]rawAction, actionParseErrors) {
+	var (
+		actions []rawAction
+		errs    actionParseErrors
+		fields  []actionField
+		field   strings.Builder
+	)
+	col := func(i int) int { return utf8.RuneCountInString(src[:i]) + 1 }
+
+	actionStart, fieldStart := 0, 0
+	endField := func() {
+		fields = append(fields, actionField{text: field.String(), column: col(fieldStart)})
+		field.Reset()
+	}
+	endAction := func(end int) {
+		endField()
+		source := src[actionStart:end]
+		if strings.TrimSpace(source) == "" {
+			errs = append(errs, &actionParseError{column: col(actionStart), msg: "empty action"})
+		} else {
+			actions = append(actions, rawAction{fields: fields, column: col(actionStart), source: source})
+		}
+		fields = nil
+	}
+
+	// Bracket tracking of the verb and selector fields
+	var brackets []int // Positions of the open brackets
+	var quote byte     // Quote character of the string within brackets, 0 if not in a string
+
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		structural := len(fields) < 2
+
+		// Quoted field
+		if c == '`' && i == fieldStart {
+			closed := false
+			j := i + 1
+			for j < len(src) {
+				if src[j] == '`' {
+					if j+1 < len(src) && src[j+1] == '`' {
+						field.WriteByte('`')
+						j += 2
+						continue
+					}
+					closed = true
+					j++
+					break
+				}
+				field.WriteByte(src[j])
+				j++
+			}
+			if !closed {
+				errs = append(errs, &actionParseError{column: col(i), msg: "unterminated backtick quote"})
+				i = len(src)
+				break
+			}
+			i = j
+			if i < len(src) && !strings.HasPrefix(src[i:], "::") && !strings.HasPrefix(src[i:], "||") {
+				errs = append(errs, &actionParseError{column: col(i), msg: "unexpected character after closing backtick, expected :: or ||"})
+				// Skip to the next separator to continue with the rest
+				for i < len(src) && !strings.HasPrefix(src[i:], "::") && !strings.HasPrefix(src[i:], "||") {
+					i++
+				}
+			}
+			continue
+		}
+
+		if c == '\\' && i+1 < len(src) {
+			next := src[i+1]
+			switch {
+			case structural:
+				field.WriteByte(c)
+				field.WriteByte(next)
+			case next == ':' || next == '|' || next == '\\':
+				field.WriteByte(next)
+			default:
+				// Not an escape, keep the backslash and process the next character as usual
+				field.WriteByte(c)
+				i++
+				continue
+			}
+			i += 2
+			continue
+		}
+
+		if structural {
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case len(brackets) > 0 && (c == '\'' || c == '"'):
+				quote = c
+			case c == '[' || c == '(':
+				brackets = append(brackets, i)
+			case c == ']' || c == ')':
+				if len(brackets) == 0 {
+					errs = append(errs, &actionParseError{column: col(i), msg: fmt.Sprintf("unbalanced %q", c)})
+				} else {
+					open := src[brackets[len(brackets)-1]]
+					if (open == '[') != (c == ']') {
+						errs = append(errs, &actionParseError{column: col(i), msg: fmt.Sprintf("%q does not match %q at column %d", c, open, col(brackets[len(brackets)-1]))})
+					}
+					brackets = brackets[:len(brackets)-1]
+				}
+			}
+			if quote != 0 || len(brackets) > 0 {
+				field.WriteByte(c)
+				i++
+				continue
+			}
+		}
+
+		if strings.HasPrefix(src[i:], "::") {
+			endField()
+			i += 2
+			fieldStart = i
+			continue
+		}
+		if strings.HasPrefix(src[i:], "||") {
+			endAction(i)
+			i += 2
+			actionStart, fieldStart = i, i
+			continue
+		}
+		field.WriteByte(c)
+		i++
+	}
+
+	if quote != 0 {
+		errs = append(errs, &actionParseError{column: col(len(src)), msg: fmt.Sprintf("unterminated %c string in selector", quote)})
+	}
+	for _, b := range brackets {
+		errs = append(errs, &actionParseError{column: col(b), msg: fmt.Sprintf("unclosed %q", src[b])})
+	}
+	endAction(len(src))
+	return actions, errs
+}
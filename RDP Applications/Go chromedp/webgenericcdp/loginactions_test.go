@@ -0,0 +1,225 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenizeLoginActions(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		fields [][]string // Fields of each action
+		cols   [][]int    // Columns of the fields of each action, nil to skip
+	}{
+		{src: "c::#login", fields: [][]string{{"c", "#login"}}, cols: [][]int{{1, 4}}},
+		{src: "v::#user::jdoe||c::#next", fields: [][]string{{"v", "#user", "jdoe"}, {"c", "#next"}}, cols: [][]int{{1, 4, 11}, {17, 20}}},
+		{src: "c::a[href*='::']", fields: [][]string{{"c", "a[href*='::']"}}},
+		{src: "c::xpath://a[text()='A||B']||c::#x", fields: [][]string{{"c", "xpath://a[text()='A||B']"}, {"c", "#x"}}, cols: [][]int{{1, 4}, {30, 33}}},
+		{src: `c::div[title="a]b"]`, fields: [][]string{{"c", `div[title="a]b"]`}}},
+		{src: "c(optional=3000)::#kmsi", fields: [][]string{{"c(optional=3000)", "#kmsi"}}},
+		{src: "c::`a::after`", fields: [][]string{{"c", "a::after"}}},
+		{src: "v::#f::`x``y||z`", fields: [][]string{{"v", "#f", "x`y||z"}}},
+		{src: `v::#f::a\:\:b\|\|c\\d`, fields: [][]string{{"v", "#f", `a::b||c\d`}}},
+		{src: `v::#f::DOMAIN\{username}`, fields: [][]string{{"v", "#f", `DOMAIN\{username}`}}},
+		{src: `c::#a\:b`, fields: [][]string{{"c", `#a\:b`}}},
+		{src: `c::a\[x`, fields: [][]string{{"c", `a\[x`}}},
+		{src: "v::#f::", fields: [][]string{{"v", "#f", ""}}},
+		{src: "v::#ü::ö||c::#x", fields: [][]string{{"v", "#ü", "ö"}, {"c", "#x"}}, cols: [][]int{{1, 4, 8}, {11, 14}}},
+	} {
+		raws, errs := tokenizeLoginActions(tc.src)
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", tc.src, errs)
+			continue
+		}
+		if len(raws) != len(tc.fields) {
+			t.Errorf("%s: %d actions, want %d", tc.src, len(raws), len(tc.fields))
+			continue
+		}
+		for i, raw := range raws {
+			var texts []string
+			var cols []int
+			for _, f := range raw.fields {
+				texts = append(texts, f.text)
+				cols = append(cols, f.column)
+			}
+			if strings.Join(texts, "\x00") != strings.Join(tc.fields[i], "\x00") {
+				t.Errorf("%s: action %d fields %q, want %q", tc.src, i, texts, tc.fields[i])
+			}
+			if tc.cols != nil && !equalInts(cols, tc.cols[i]) {
+				t.Errorf("%s: action %d columns %v, want %v", tc.src, i, cols, tc.cols[i])
+			}
+			if raw.column != cols[0] {
+				t.Errorf("%s: action %d column %d, want %d", tc.src, i, raw.column, cols[0])
+			}
+		}
+	}
+}
+
+func TestTokenizeLoginActionsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		errs []string
+	}{
+		{"", []string{"column 1: empty action"}},
+		{"c::#a||||c::#b", []string{"column 8: empty action"}},
+		{"c::#a||", []string{"column 8: empty action"}},
+		{"c::`a::after", []string{"column 4: unterminated backtick quote"}},
+		{"c::`a`b||c::#x", []string{"column 7: unexpected character after closing backtick, expected :: or ||"}},
+		{"c::a[href='x'", []string{"column 5: unclosed '['"}},
+		{"c::a[href='x]", []string{"column 14: unterminated ' string in selector", "column 5: unclosed '['"}},
+		{"c::a]", []string{"column 5: unbalanced ']'"}},
+		{"c::a[x)", []string{"column 7: ')' does not match '[' at column 5"}},
+	} {
+		_, errs := tokenizeLoginActions(tc.src)
+		if len(errs) == 0 {
+			t.Errorf("%q: no error", tc.src)
+			continue
+		}
+		if got := errs.Error(); got != strings.Join(tc.errs, "; ") {
+			t.Errorf("%q: errors %q, want %q", tc.src, got, strings.Join(tc.errs, "; "))
+		}
+	}
+}
+
+func TestParseLoginActions(t *testing.T) {
+	actions, err := parseLoginActions("c(optional)::#kmsi||v(timeout=2s)::#user::{username}@corp||s::#pw::password||o(totp,digits=8)::#otp::Target.TotpSeed::5||" +
+		"wait-url::^https://app/||sleep::1500||v::#f::kb.Enter||v::#g::plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		kind     actionKind
+		selector string
+		value    valueKind
+		optional time.Duration
+		timeout  time.Duration
+	}{
+		{actionClick, "#kmsi", valueStatic, defaultOptionalWait, 0},
+		{actionValue, "#user", valueSafeguard, 0, 2 * time.Second},
+		{actionSecret, "#pw", valueSafeguard, 0, 0},
+		{actionOTP, "#otp", valueSafeguard, 0, 0},
+		{actionWaitURL, "^https://app/", valueStatic, 0, 0},
+		{actionSleep, "1500", valueStatic, 0, 0},
+		{actionValue, "#f", valueKeyboard, 0, 0},
+		{actionValue, "#g", valueStatic, 0, 0},
+	} {
+		a := actions[i]
+		if a.kind != want.kind || a.selector != want.selector || a.value.kind != want.value || a.optional != want.optional || a.timeout != want.timeout {
+			t.Errorf("action %d: %s %q value %d optional %s timeout %s, want %+v", i, a.kind, a.selector, a.value.kind, a.optional, a.timeout, want)
+		}
+	}
+	if actions[3].minSecondsBeforeExpiry != 5 || actions[3].otp.digits != 8 {
+		t.Errorf("o action: %+v", actions[3])
+	}
+	if actions[4].pattern == nil || actions[5].sleep != 1500*time.Millisecond {
+		t.Errorf("wait-url pattern %v, sleep %s", actions[4].pattern, actions[5].sleep)
+	}
+	if len(actions[6].value.keys) != 1 {
+		t.Errorf("keys of kb.Enter: %v", actions[6].value.keys)
+	}
+}
+
+func TestParseLoginActionsBlocks(t *testing.T) {
+	actions, err := parseLoginActions("if(within=2s)::#kmsi||c::#no||else||c::#skip||end||" +
+		"first(optional)||case::#a||c::#a||case::#b||v::#b::x||c::#b2||end||c::#done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 3 {
+		t.Fatalf("%d actions, want 3", len(actions))
+	}
+	ifBlock := actions[0]
+	if ifBlock.kind != actionIf || ifBlock.within != 2*time.Second || len(ifBlock.branches) != 1 || ifBlock.branches[0].selector != "#kmsi" ||
+		len(ifBlock.branches[0].actions) != 1 || len(ifBlock.otherwise) != 1 || ifBlock.otherwise[0].selector != "#skip" {
+		t.Errorf("if block: %+v", ifBlock)
+	}
+	first := actions[1]
+	if first.kind != actionFirst || first.within != defaultOptionalWait || len(first.branches) != 2 ||
+		len(first.branches[0].actions) != 1 || len(first.branches[1].actions) != 2 || first.branches[1].selector != "#b" {
+		t.Errorf("first block: %+v", first)
+	}
+	if actions[2].selector != "#done" {
+		t.Errorf("action after the blocks: %+v", actions[2])
+	}
+}
+
+func TestParseLoginActionsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		errs []string
+	}{
+		{"x::#a", []string{`column 1: unknown action "x", supported actions: c, v, s, o, wait-visible, wait-gone, wait-url, wait-title, sleep, if, first`}},
+		{"c::#a::b", []string{"column 1: c action with improper number of configuration items. Format: c::<selector>"}},
+		{"c::#a||v::#b", []string{"column 8: v action with improper number of configuration items. Format: v::<selector>::<value>"}},
+		{"c::", []string{"column 1: missing selector. Format: c::<selector>"}},
+		{"c::css:", []string{"column 4: missing selector after css:. Format: c::<selector>"}},
+		{"c(within=1s)::#a", []string{`column 3: unknown option "within" of c, supported options: optional, timeout`}},
+		{"c(optional=0)::#a", []string{`column 3: optional: "0" must be a positive number of milliseconds or a duration like 5s`}},
+		{"c(optional::#a", []string{"column 2: unclosed '('", "column 15: options of c must be closed with )"}},
+		{"c(, timeout=1s)::#a", []string{"column 3: empty option of c"}},
+		{"s::#a::{user", []string{"column 8: placeholder is not closed with }, use {{ for a literal {"}},
+		{"s::#a::{pw:bogus}", []string{`column 8: unknown transform "bogus" in placeholder {pw:bogus}, supported transforms: lower, netbios, strip-domain, upper, urlencode`}},
+		{"s::#a::", []string{"column 8: missing Safeguard value name. Format: s::<selector>::<secret-value>"}},
+		{"o::#a::codes::-1", []string{`column 15: min-seconds-before-expiry must be a non-negative number, got "-1"`}},
+		{"wait-title::a**", []string{"column 13: invalid regular expression: error parsing regexp: invalid nested repetition operator: `**`"}},
+		{"sleep::soon", []string{`column 8: "soon" must be a positive number of milliseconds or a duration like 5s`}},
+		{"sleep(timeout=1s)::1s", []string{"column 7: sleep does not accept options"}},
+		{"v::#a::kb.Nope", []string{"column 8: "}},
+		{"if::#a||c::#b", []string{"column 1: if block is not closed with end"}},
+		{"c::#a||end", []string{"column 8: end without a matching if or first"}},
+		{"first||c::#a||end", []string{"column 8: first must be followed by case::<selector>"}},
+		{"if::#a||c::#b||case::#c||end", []string{"column 16: unexpected case in if block started at column 1, expected end", "column 26: end without a matching if or first"}},
+		{"first(optional=1s)||case::#a||end", []string{"column 7: optional of first does not take a value, use within to set the wait period"}},
+		{"x::#a||c::#b::c", []string{`column 1: unknown action "x"`, "column 8: c action with improper number"}},
+	} {
+		_, err := parseLoginActions(tc.src)
+		if err == nil {
+			t.Errorf("%s: no error", tc.src)
+			continue
+		}
+		msgs := splitErrors(err)
+		if len(msgs) != len(tc.errs) {
+			t.Errorf("%s: %d errors %q, want %d", tc.src, len(msgs), err, len(tc.errs))
+			continue
+		}
+		for i, want := range tc.errs {
+			if !strings.HasPrefix(msgs[i].Error(), want) {
+				t.Errorf("%s: error %q, want %q", tc.src, msgs[i], want)
+			}
+		}
+	}
+}
+
+func TestParseActionDuration(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"3000", 3 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"0", 0, false},
+		{"-5s", 0, false},
+		{"", 0, false},
+		{"5 s", 0, false},
+	} {
+		got, err := parseActionDuration(tc.s)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseActionDuration(%q) = %s, %v", tc.s, got, err)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package main
+
+// Success and failure conditions of the login, checked after the last action, like the SUCCESS and FAILURE
+// states of the .tps pattern files.
+//
+// Grammar of the successConditions and failureConditions settings, tokenized like loginActions:
+//
+//	conditions = condition { "||" condition }
+//	condition  = kind [ "(reason=" reason ")" ] "::" field   (reason: failureConditions only)
+//	kind       = "url" | "title" | "text" | "element"
+//
+// url, title and text match a regular expression against the URL, the title and the visible text of the page,
+// element is satisfied if the selector is visible.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+type conditionKind string
+
+const (
+	conditionURL     conditionKind = "url"     // url::<regex>
+	conditionTitle   conditionKind = "title"   // title::<regex>
+	conditionText    conditionKind = "text"    // text::<regex>
+	conditionElement conditionKind = "element" // element::<selector>
+)
+
+// Reasons of the failure conditions and the exit codes they lead to
+var failureReasons = map[string]int{
+	"failed":              exitLoginFailed,
+	"invalid-credentials": exitInvalidCredentials,
+	"account-locked":      exitAccountLocked,
+	"password-expired":    exitPasswordExpired,
+}
+
+// loginCondition is a single parsed entry of successConditions or failureConditions
+type loginCondition struct {
+	kind     conditionKind
+	pattern  *regexp.Regexp // url, title and text conditions
+	selector string         // element condition
+	reason   string         // Failure conditions only
+	column   int
+	source   string
+}
+
+// parseConditions parses successConditions (failure false) or failureConditions (failure true).
+// Errors are collected for all conditions, the returned error is an actionParseErrors.
+func parseConditions(src string, failure bool) ([]loginCondition, error) {
+	raws, errs := tokenizeLoginActions(src)
+	var conditions []loginCondition
+	for _, raw := range raws {
+		c, err := parseCondition(raw, failure)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		conditions = append(conditions, c)
+	}
+	if len(errs) > 0 {
+		return conditions, errs
+	}
+	return conditions, nil
+}
+
+func parseCondition(raw rawAction, failure bool) (loginCondition, *actionParseError) {
+	verb := raw.fields[0]
+	name, options, err := parseVerb(verb)
+	c := loginCondition{kind: conditionKind(name), column: raw.column, source: raw.source}
+	if err != nil {
+		return c, err
+	}
+	switch c.kind {
+	case conditionURL, conditionTitle, conditionText, conditionElement:
+	default:
+		return c, &actionParseError{column: verb.column, msg: fmt.Sprintf("unknown condition %q, supported conditions: url, title, text, element", name)}
+	}
+	formatHelp := name + "::<regex>"
+	if c.kind == conditionElement {
+		formatHelp = name + "::<selector>"
+	}
+	if len(raw.fields) != 2 || raw.fields[1].text == "" {
+		return c, &actionParseError{column: verb.column, msg: fmt.Sprintf("%s condition with improper number of configuration items. Format: %s", name, formatHelp)}
+	}
+
+	if failure {
+		c.reason = "failed"
+	}
+	for _, opt := range options {
+		switch {
+		case opt.name != "reason":
+			return c, &actionParseError{column: opt.column, msg: fmt.Sprintf("unknown option %q of %s, supported options: reason", opt.name, name)}
+		case !failure:
+			return c, &actionParseError{column: opt.column, msg: "reason is only supported in failureConditions"}
+		}
+		if _, ok := failureReasons[opt.value]; !ok {
+			reasons := make([]string, 0, len(failureReasons))
+			for r := range failureReasons {
+				reasons = append(reasons, r)
+			}
+			slices.Sort(reasons)
+			return c, &actionParseError{column: opt.column, msg: fmt.Sprintf("reason: %q is not supported, accepted values: %s", opt.value, strings.Join(reasons, "|"))}
+		}
+		c.reason = opt.value
+	}
+
+	field := raw.fields[1]
+	if c.kind == conditionElement {
+		if strategy, rest := splitSelectorStrategy(field.text); strategy != "" && strings.TrimSpace(rest) == "" {
+			return c, &actionParseError{column: field.column, msg: fmt.Sprintf("missing selector after %s:. Format: %s", strategy, formatHelp)}
+		}
+		c.selector = field.text
+		return c, nil
+	}
+	re, rerr := regexp.Compile(field.text)
+	if rerr != nil {
+		return c, &actionParseError{column: field.column, msg: fmt.Sprintf("invalid regular expression: %s", rerr)}
+	}
+	c.pattern = re
+	return c, nil
+}
+
+// loginResultError reports a login which failed or could not be confirmed by the conditions, with the exit code telling why
+type loginResultError struct {
+	reason    string // Reason of the failure condition, or unconfirmed
+	condition string // The matching failure condition as written in the configuration
+	timeout   time.Duration
+	exitCode  int
+}
+
+func (e *loginResultError) Error() string {
+	if e.condition == "" {
+		return fmt.Sprintf("login could not be confirmed, none of the successConditions matched within %s", e.timeout)
+	}
+	return fmt.Sprintf("login failed (%s), failure condition matched: %s", e.reason, e.condition)
+}
+
+// matches checks the condition once against the current page
+func (c loginCondition) matches(ctx context.Context, queryOption chromedp.QueryOption) (bool, error) {
+	var s string
+	var err error
+	switch c.kind {
+	case conditionElement:
+		i, err := waitForFirstElement(ctx, []elementSelector{resolveSelector(c.selector, queryOption)}, elementProbeTimeout)
+		return i >= 0, err
+	case conditionURL:
+		err = chromedp.Location(&s).Do(ctx)
+	case conditionTitle:
+		err = chromedp.Title(&s).Do(ctx)
+	default:
+		err = chromedp.Evaluate(`document.body ? document.body.innerText : ""`, &s).Do(ctx)
+	}
+	return err == nil && c.pattern.MatchString(s), err
+}
+
+// checkLoginResult polls the conditions until a failure or a success condition matches, for at most timeout.
+// Failure conditions take precedence. Without success conditions the login is successful if no failure condition matched within timeout.
+func checkLoginResult(success []loginCondition, failure []loginCondition, queryOption chromedp.QueryOption, timeout time.Duration, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		start := time.Now()
+		for {
+			for _, c := range failure {
+				matched, err := c.matches(ctx, queryOption)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					// The page may be navigating, the next poll checks again
+					slog.Debug("[loginResult] Cannot check condition", "condition", c.source, "error", err.Error(), "sessionid", uuid)
+				}
+				if matched {
+					rerr := &loginResultError{reason: c.reason, condition: c.source, exitCode: failureReasons[c.reason]}
+					slog.Error("[loginResult] Login failed", "event", "login_failed", "reason", c.reason, "condition", c.source, "exit_code", rerr.exitCode, "sessionid", uuid)
+					return rerr
+				}
+			}
+			for _, c := range success {
+				matched, err := c.matches(ctx, queryOption)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					slog.Debug("[loginResult] Cannot check condition", "condition", c.source, "error", err.Error(), "sessionid", uuid)
+				}
+				if matched {
+					slog.Info("[loginResult] Login succeeded", "event", "login_succeeded", "condition", c.source, "sessionid", uuid)
+					return nil
+				}
+			}
+			if time.Since(start) >= timeout {
+				if len(success) == 0 {
+					slog.Info("[loginResult] Login succeeded, none of the failureConditions matched", "event", "login_succeeded", "assertionTimeout", timeout.String(), "sessionid", uuid)
+					return nil
+				}
+				rerr := &loginResultError{reason: "unconfirmed", timeout: timeout, exitCode: exitLoginUnconfirmed}
+				slog.Error("[loginResult] Login could not be confirmed", "event", "login_unconfirmed", "assertionTimeout", timeout.String(), "exit_code", rerr.exitCode, "sessionid", uuid)
+				return rerr
+			}
+			if err := chromedp.Sleep(waitPollInterval).Do(ctx); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// addLoginResultCheck appends the check of the success and failure conditions to the plan, if any is configured
+func addLoginResultCheck(plan *taskPlan, config Config, queryOption chromedp.QueryOption, uuid string) error {
+	if config.successConditions == "" && config.failureConditions == "" {
+		return nil
+	}
+	// Either setting may be left empty
+	var success, failure []loginCondition
+	var err error
+	if config.successConditions != "" {
+		if success, err = parseConditions(config.successConditions, false); err != nil {
+			return fmt.Errorf("successConditions %w", err)
+		}
+	}
+	if config.failureConditions != "" {
+		if failure, err = parseConditions(config.failureConditions, true); err != nil {
+			return fmt.Errorf("failureConditions %w", err)
+		}
+	}
+	sub := &taskPlan{}
+	for _, c := range failure {
+		sub.describe("Failure (" + c.reason + ", exit code " + strconv.Itoa(failureReasons[c.reason]) + ") if " + c.source)
+	}
+	for _, c := range success {
+		sub.describe("Success if " + c.source)
+	}
+	if len(success) == 0 {
+		sub.describe("Success if no failure condition matches")
+	} else {
+		sub.describe("Otherwise unconfirmed (exit code " + strconv.Itoa(exitLoginUnconfirmed) + ")")
+	}
+	plan.add(checkLoginResult(success, failure, queryOption, config.assertionTimeout, uuid), "Check the login result within "+config.assertionTimeout.String()+":")
+	plan.nest(sub)
+	slog.Debug("[taskList] Check login result", "successConditions", len(success), "failureConditions", len(failure), "assertionTimeout", config.assertionTimeout.String(), "sessionid", uuid)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestAddLoginResultCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		success string
+		failure string
+		steps   []string // Expected descriptions, nil when no check is added
+		err     string
+	}{
+		{name: "none"},
+		{
+			name:    "failure only",
+			failure: "text(reason=invalid-credentials)::(?i)incorrect password",
+			steps:   []string{"Failure (invalid-credentials, exit code 4) if text(reason=invalid-credentials)::(?i)incorrect password", "Success if no failure condition matches"},
+		},
+		{
+			name:    "success only",
+			success: "url::^https://portal\\.example\\.com/home||element::css:#user-menu",
+			steps:   []string{"Success if url::^https://portal\\.example\\.com/home", "Success if element::css:#user-menu", "Otherwise unconfirmed (exit code 7)"},
+		},
+		{
+			name:    "both",
+			success: "title::Home",
+			failure: "element::#error",
+			steps:   []string{"Failure (failed, exit code 3) if element::#error", "Success if title::Home", "Otherwise unconfirmed (exit code 7)"},
+		},
+		{name: "invalid success", success: "url::a**", err: "successConditions column 6: invalid regular expression"},
+		{name: "invalid failure", success: "title::Home", failure: "text::a||bogus::x", err: "failureConditions column 10: unknown condition \"bogus\""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.successConditions, config.failureConditions = tc.success, tc.failure
+			plan := &taskPlan{}
+			err := addLoginResultCheck(plan, config, chromedp.ByQuery, "test")
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.steps == nil {
+				if len(plan.tasks) != 0 {
+					t.Errorf("check added without conditions: %q", plan.steps)
+				}
+				return
+			}
+			if len(plan.tasks) != 1 {
+				t.Fatalf("%d tasks, want 1", len(plan.tasks))
+			}
+			want := append([]string{"Check the login result within 10s:"}, tc.steps...)
+			for i := 1; i < len(want); i++ {
+				want[i] = "    " + want[i]
+			}
+			if strings.Join(plan.steps, "\n") != strings.Join(want, "\n") {
+				t.Errorf("steps\n%s\nwant\n%s", strings.Join(plan.steps, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	for _, tc := range []struct {
+		src     string
+		failure bool
+		kinds   []conditionKind
+		reasons []string
+		err     string
+	}{
+		{src: "url::^https://a/||title::Home||text::Welcome||element::xpath://div", kinds: []conditionKind{conditionURL, conditionTitle, conditionText, conditionElement}, reasons: []string{"", "", "", ""}},
+		{src: "text::a||text(reason=account-locked)::b", failure: true, kinds: []conditionKind{conditionText, conditionText}, reasons: []string{"failed", "account-locked"}},
+		{src: "text(reason=failed)::a", err: "column 6: reason is only supported in failureConditions"},
+		{src: "text(reason=unknown)::a", failure: true, err: `column 6: reason: "unknown" is not supported, accepted values: account-locked|failed|invalid-credentials|password-expired`},
+		{src: "text(timeout=5)::a", failure: true, err: `column 6: unknown option "timeout" of text`},
+		{src: "url", err: "column 1: url condition with improper number of configuration items. Format: url::<regex>"},
+		{src: "element::css:", err: "column 10: missing selector after css:. Format: element::<selector>"},
+		{src: "title::a||url::a**", err: "column 16: invalid regular expression"},
+	} {
+		conditions, err := parseConditions(tc.src, tc.failure)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.src, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if len(conditions) != len(tc.kinds) {
+			t.Errorf("%s: %d conditions, want %d", tc.src, len(conditions), len(tc.kinds))
+			continue
+		}
+		for i, c := range conditions {
+			if c.kind != tc.kinds[i] || c.reason != tc.reasons[i] {
+				t.Errorf("%s: condition %d is %s with reason %q, want %s with %q", tc.src, i, c.kind, c.reason, tc.kinds[i], tc.reasons[i])
+			}
+		}
+	}
+}
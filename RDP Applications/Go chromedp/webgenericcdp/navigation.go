@@ -0,0 +1,444 @@
+package main
+
+// Confinement of the browser to the published web application. The top-level navigations of every window, including
+// the windows opened later by the page or by the user, are paused through the CDP Fetch domain and checked against
+// navigationDeny and navigationAllow. A blocked navigation shows a page telling the user so instead of the requested one.
+// The Fetch domain does not see the navigations to other schemes than http and https, like file:// or chrome://settings
+// typed in the address bar. They are blocked once they are committed, by showing the page instead, except the new tab page.
+//
+// Grammar of the entries:
+//
+//	entry = [ scheme "://" ] host [ ":" port ] [ path ]
+//
+// host and path may contain * for any characters, *.example.com matches the subdomains of example.com, not
+// example.com itself. Without a scheme http and https match, without a port any port, without a path any path.
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// Page shown instead of a blocked navigation, %s is the HTML escaped URL
+const navigationBlockedPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Page not available</title>
+<style>body{font-family:Segoe UI,Arial,sans-serif;margin:4em auto;max-width:40em;color:#333}code{word-break:break-all;color:#666}</style>
+</head><body>
+<h1>This page is not available in this session</h1>
+<p>The session is restricted to the published web application. The following address is not allowed:</p>
+<p><code>%s</code></p>
+<p>Use the back button of the browser to return to the application.</p>
+</body></html>`
+
+// Pages of other schemes than http and https which may be shown, the new windows and tabs open them first
+var navigationInternalPages = map[string]bool{
+	"about:blank":                              true,
+	"chrome://newtab/":                         true,
+	"chrome://new-tab-page/":                   true,
+	"edge://newtab/":                           true,
+	"chrome-search://local-ntp/local-ntp.html": true,
+}
+
+// navigationRule is a parsed entry of navigationAllow or navigationDeny
+type navigationRule struct {
+	scheme string // Empty for http and https
+	host   *regexp.Regexp
+	port   string // Empty for any port
+	path   *regexp.Regexp
+	source string
+}
+
+// globPattern returns the regular expression of a glob where * matches any characters
+func globPattern(glob string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*") + "$")
+}
+
+func parseNavigationRule(entry string) (navigationRule, error) {
+	rule := navigationRule{source: entry}
+	rest := entry
+	if scheme, after, found := strings.Cut(entry, "://"); found {
+		if scheme != "http" && scheme != "https" {
+			return rule, fmt.Errorf("%q: scheme %s is not supported, use http or https", entry, scheme)
+		}
+		rule.scheme, rest = scheme, after
+	}
+	hostPort, path, _ := strings.Cut(rest, "/")
+	if strings.ContainsAny(hostPort, "?#@") || strings.ContainsAny(path, "?#") {
+		return rule, fmt.Errorf("%q must be a host with an optional path, without user, query or fragment", entry)
+	}
+	host := hostPort
+	if i := strings.LastIndex(hostPort, ":"); i >= 0 && !strings.HasSuffix(hostPort, "]") {
+		host, rule.port = hostPort[:i], hostPort[i+1:]
+		if rule.port == "" || strings.Trim(rule.port, "0123456789") != "" {
+			return rule, fmt.Errorf("%q: port %q is not a number", entry, rule.port)
+		}
+	}
+	host = strings.Trim(strings.ToLower(host), "[]")
+	if host == "" {
+		return rule, fmt.Errorf("%q has no host, use * for any host", entry)
+	}
+	rule.host = globPattern(host)
+	if path != "" {
+		rule.path = globPattern("/" + path)
+	}
+	return rule, nil
+}
+
+// matches reports whether u matches the rule, the query and the fragment of u are not considered
+func (r navigationRule) matches(u *url.URL) bool {
+	if (r.scheme == "" && u.Scheme != "http" && u.Scheme != "https") || (r.scheme != "" && u.Scheme != r.scheme) {
+		return false
+	}
+	if r.port != "" {
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		}
+		if port != r.port {
+			return false
+		}
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return r.host.MatchString(strings.ToLower(u.Hostname())) && (r.path == nil || r.path.MatchString(path))
+}
+
+// navigationFilter decides which top-level navigations are allowed
+type navigationFilter struct {
+	allow []navigationRule
+	deny  []navigationRule
+	guard *originGuard // The origins of url and idpDomains are allowed with an allowlist, so the login can be performed
+
+	mu        sync.Mutex
+	fulfilled map[target.ID]string // Navigation to another scheme than http and https answered with the blocked page, by target
+}
+
+// newNavigationFilter returns nil if neither navigationAllow nor navigationDeny is configured
+func newNavigationFilter(allow []string, deny []string, guard *originGuard) (*navigationFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &navigationFilter{guard: guard, fulfilled: map[target.ID]string{}}
+	for _, entry := range allow {
+		rule, err := parseNavigationRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("navigationAllow: %w", err)
+		}
+		f.allow = append(f.allow, rule)
+	}
+	for _, entry := range deny {
+		rule, err := parseNavigationRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("navigationDeny: %w", err)
+		}
+		f.deny = append(f.deny, rule)
+	}
+	return f, nil
+}
+
+func (f *navigationFilter) String() string {
+	var parts []string
+	if len(f.allow) > 0 {
+		sources := []string{f.guard.String()}
+		for _, r := range f.allow {
+			sources = append(sources, r.source)
+		}
+		parts = append(parts, "allow only "+strings.Join(sources, ", "))
+	}
+	if len(f.deny) > 0 {
+		sources := make([]string, len(f.deny))
+		for i, r := range f.deny {
+			sources[i] = r.source
+		}
+		parts = append(parts, "deny "+strings.Join(sources, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// check returns the reason if the navigation to rawURL is blocked, or "" if it is allowed. navigationDeny takes precedence.
+func (f *navigationFilter) check(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		if navigationInternalPages[rawURL] || strings.HasPrefix(rawURL, blockedPageURLPrefix) {
+			return ""
+		}
+		return "scheme " + u.Scheme + " is not allowed"
+	}
+	for _, r := range f.deny {
+		if r.matches(u) {
+			return "denied by " + r.source
+		}
+	}
+	if len(f.allow) == 0 {
+		return ""
+	}
+	if _, ok := f.guard.allowed(originOf(u)); ok {
+		return ""
+	}
+	for _, r := range f.allow {
+		if r.matches(u) {
+			return ""
+		}
+	}
+	return "not in navigationAllow"
+}
+
+// fetchPatterns returns the Fetch domain patterns of the navigations, the requests of the documents
+func (f *navigationFilter) fetchPatterns() []*fetch.RequestPattern {
+	return []*fetch.RequestPattern{{URLPattern: "*", ResourceType: network.ResourceTypeDocument}}
+}
+
+// paused handles a request paused by the Fetch domain of the target if it is a top-level navigation, and reports whether it did.
+// It must be called outside of the listener of the events, as it sends commands.
+func (f *navigationFilter) paused(ctx context.Context, targetID target.ID, ev *fetch.EventRequestPaused, uuid string) bool {
+	if ev.ResourceType != network.ResourceTypeDocument || string(ev.FrameID) != string(targetID) {
+		return false
+	}
+	reason := f.check(ev.Request.URL)
+	if reason == "" {
+		return false
+	}
+	slog.Warn("[navigation] Navigation blocked", "event", "navigation_blocked", "url", ev.Request.URL, "reason", reason, "sessionid", uuid)
+	if !strings.HasPrefix(ev.Request.URL, "http") {
+		// Some, like file://, are paused too, their blocked page must not be blocked again once committed
+		f.mu.Lock()
+		f.fulfilled[targetID] = ev.Request.URL
+		f.mu.Unlock()
+	}
+	if err := fulfillBlockedPage(ctx, ev.RequestID, ev.Request.URL); err != nil {
+		slog.Error("[navigation] Cannot block navigation", "error", err.Error(), "sessionid", uuid)
+	}
+	return true
+}
+
+// navigated blocks a top-level navigation of the target to another scheme than http and https, once committed, by
+// showing the page telling the user that rawURL is not available. It must be called outside of the listener of the events.
+func (f *navigationFilter) navigated(ctx context.Context, targetID target.ID, rawURL string, uuid string) {
+	if rawURL == "" || strings.HasPrefix(rawURL, "http:") || strings.HasPrefix(rawURL, "https:") {
+		// No page yet, or paused by the Fetch domain
+		return
+	}
+	f.mu.Lock()
+	fulfilled := f.fulfilled[targetID] == rawURL
+	delete(f.fulfilled, targetID)
+	f.mu.Unlock()
+	if fulfilled {
+		return
+	}
+	reason := f.check(rawURL)
+	if reason == "" {
+		return
+	}
+	slog.Warn("[navigation] Navigation blocked", "event", "navigation_blocked", "url", rawURL, "reason", reason, "sessionid", uuid)
+	if _, _, _, _, err := page.Navigate(blockedPageURL(rawURL)).Do(ctx); err != nil {
+		slog.Error("[navigation] Cannot block navigation", "error", err.Error(), "sessionid", uuid)
+	}
+}
+
+// blocks reports whether a page already loaded from rawURL must be reloaded through the filter
+func (f *navigationFilter) blocks(rawURL string, _ string) bool {
+	return f.check(rawURL) != ""
+}
+
+// blockedPage returns the page telling the user that rawURL is not available
+func blockedPage(rawURL string) string {
+	return fmt.Sprintf(navigationBlockedPage, html.EscapeString(secrets.redact(rawURL)))
+}
+
+// Start of the data: URL of the blocked page, allowed by the filter
+var blockedPageURLPrefix = "data:text/html;charset=utf-8," + url.PathEscape(navigationBlockedPage[:strings.Index(navigationBlockedPage, "%s")])
+
+// blockedPageURL returns the data: URL of the page telling the user that rawURL is not available, for the
+// navigations not paused by the Fetch domain
+func blockedPageURL(rawURL string) string {
+	return "data:text/html;charset=utf-8," + url.PathEscape(blockedPage(rawURL))
+}
+
+// fulfillBlockedPage answers a paused request with the page telling the user that rawURL is not available
+func fulfillBlockedPage(ctx context.Context, requestID fetch.RequestID, rawURL string) error {
+	return fetch.FulfillRequest(requestID, 403).
+		WithResponseHeaders([]*fetch.HeaderEntry{{Name: "Content-Type", Value: "text/html; charset=utf-8"}, {Name: "Cache-Control", Value: "no-store"}}).
+		WithBody(base64.StdEncoding.EncodeToString([]byte(blockedPage(rawURL)))).
+		Do(ctx)
+}
+
+// requestFilter checks the requests of every window paused by the Fetch domain. A target has a single set of Fetch
+// patterns, so the navigation filter, the certificate scope and the answering of authentication challenges share it.
+type requestFilter interface {
+	fetchPatterns() []*fetch.RequestPattern
+	// paused handles the paused request if it is blocked, and reports whether it did. It must be called outside of the listener of the events.
+	paused(ctx context.Context, targetID target.ID, ev *fetch.EventRequestPaused, uuid string) bool
+	// blocks reports whether a page already loaded from rawURL must be reloaded through the filter
+	blocks(rawURL string, uuid string) bool
+}
+
+// navigationWatcher is a requestFilter checking the committed top-level navigations too, which the Fetch domain
+// does not pause for other schemes than http and https
+type navigationWatcher interface {
+	// navigated checks the navigation of the target to rawURL. It must be called outside of the listener of the events.
+	navigated(ctx context.Context, targetID target.ID, rawURL string, uuid string)
+}
+
+// responseFilter is a requestFilter checking the responses of the requests too, through the Network domain
+type responseFilter interface {
+	// responded checks the response. It must be called outside of the listener of the events.
+	responded(ctx context.Context, targetID target.ID, ev *network.EventResponseReceived, uuid string)
+}
+
+// requestFilterPatterns returns the Fetch domain patterns of the filters
+func requestFilterPatterns(filters []requestFilter) []*fetch.RequestPattern {
+	var patterns []*fetch.RequestPattern
+	for _, f := range filters {
+		patterns = append(patterns, f.fetchPatterns()...)
+	}
+	return patterns
+}
+
+// filterPaused passes the paused request to the filters, it is continued unless one of them blocked it
+func filterPaused(ctx context.Context, targetID target.ID, filters []requestFilter, ev *fetch.EventRequestPaused, uuid string) {
+	for _, f := range filters {
+		if f.paused(ctx, targetID, ev, uuid) {
+			return
+		}
+	}
+	if err := fetch.ContinueRequest(ev.RequestID).Do(ctx); err != nil {
+		slog.Debug("[navigation] Cannot continue request", "error", err.Error(), "sessionid", uuid)
+	}
+}
+
+// filterNavigated passes the committed top-level navigation to the filters checking the navigations
+func filterNavigated(ctx context.Context, targetID target.ID, filters []requestFilter, ev *page.EventFrameNavigated, uuid string) {
+	if ev.Frame.ParentID != "" {
+		return
+	}
+	for _, f := range filters {
+		if f, ok := f.(navigationWatcher); ok {
+			f.navigated(ctx, targetID, ev.Frame.URL, uuid)
+		}
+	}
+}
+
+// filterResponse passes the response to the filters checking the responses
+func filterResponse(ctx context.Context, targetID target.ID, filters []requestFilter, ev *network.EventResponseReceived, uuid string) {
+	for _, f := range filters {
+		if f, ok := f.(responseFilter); ok {
+			f.responded(ctx, targetID, ev, uuid)
+		}
+	}
+}
+
+// enableResponseFilters enables the Network domain of the target of ctx if one of the filters checks the responses
+func enableResponseFilters(ctx context.Context, filters []requestFilter) error {
+	for _, f := range filters {
+		if _, ok := f.(responseFilter); ok {
+			return network.Enable().Do(ctx)
+		}
+	}
+	return nil
+}
+
+// interceptRequests pauses the requests of the target of ctx and checks them with the filters
+func interceptRequests(filters []requestFilter, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		lctx := context.WithoutCancel(ctx)
+		c := chromedp.FromContext(ctx)
+		chromedp.ListenTarget(lctx, func(ev any) {
+			switch ev := ev.(type) {
+			case *fetch.EventRequestPaused:
+				go filterPaused(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			case *network.EventResponseReceived:
+				go filterResponse(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			case *page.EventFrameNavigated:
+				go filterNavigated(cdp.WithExecutor(lctx, c.Target), c.Target.TargetID, filters, ev, uuid)
+			}
+		})
+		if err := enableResponseFilters(ctx, filters); err != nil {
+			return err
+		}
+		return fetch.Enable().WithPatterns(requestFilterPatterns(filters)).Do(ctx)
+	})
+}
+
+// confineNewWindows applies the filters to the windows and tabs opened later, by the page or by the user.
+// The first request of a window may be sent before its requests are paused, so its address is checked again.
+func confineNewWindows(filters []requestFilter, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		attachNewWindows(ctx, func(wctx context.Context, info *target.Info) {
+			var location string
+			if err := chromedp.Run(wctx, interceptRequests(filters, uuid), chromedp.Location(&location)); err != nil {
+				slog.Error("[navigation] Cannot confine new window", "url", info.URL, "error", err.Error(), "sessionid", uuid)
+				return
+			}
+			slog.Debug("[navigation] New window confined", "url", location, "sessionid", uuid)
+			if !strings.HasPrefix(location, "http") {
+				ectx := cdp.WithExecutor(wctx, chromedp.FromContext(wctx).Target)
+				for _, f := range filters {
+					if f, ok := f.(navigationWatcher); ok {
+						f.navigated(ectx, info.TargetID, location, uuid)
+					}
+				}
+				return
+			}
+			for _, f := range filters {
+				if f.blocks(location, uuid) {
+					// Reloaded through the filters, which block the page
+					if err := chromedp.Run(wctx, chromedp.Reload()); err != nil {
+						slog.Error("[navigation] Cannot block new window", "url", location, "error", err.Error(), "sessionid", uuid)
+					}
+					return
+				}
+			}
+		})
+		return nil
+	})
+}
+
+// attachNewWindows calls attached in a goroutine for every window and tab opened later than the target of ctx, with a
+// context of the new target which is cancelled when the window is closed. It stays active for the whole browser session.
+func attachNewWindows(ctx context.Context, attached func(wctx context.Context, info *target.Info)) {
+	lctx := context.WithoutCancel(ctx)
+	current := chromedp.FromContext(ctx).Target.TargetID
+	var mu sync.Mutex
+	windows := map[target.ID]context.CancelFunc{}
+	chromedp.ListenBrowser(lctx, func(ev any) {
+		switch ev := ev.(type) {
+		case *target.EventTargetCreated:
+			info := ev.TargetInfo
+			if info.Type != "page" || info.TargetID == current {
+				return
+			}
+			// The context attaches to the target when it runs its first action
+			wctx, cancel := chromedp.NewContext(lctx, chromedp.WithTargetID(info.TargetID))
+			mu.Lock()
+			windows[info.TargetID] = cancel
+			mu.Unlock()
+			go attached(wctx, info)
+		case *target.EventTargetDestroyed:
+			mu.Lock()
+			cancel, ok := windows[ev.TargetID]
+			delete(windows, ev.TargetID)
+			mu.Unlock()
+			if ok {
+				cancel()
+			}
+		}
+	})
+}
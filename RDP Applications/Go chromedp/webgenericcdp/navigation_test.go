@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestParseNavigationRule(t *testing.T) {
+	for _, tc := range []struct {
+		entry  string
+		scheme string
+		host   string // Matched by the host pattern
+		port   string
+		err    string
+	}{
+		{entry: "docs.example.com", host: "docs.example.com"},
+		{entry: "https://docs.example.com/help/*", scheme: "https", host: "docs.example.com"},
+		{entry: "*.example.com:8443", host: "a.b.example.com", port: "8443"},
+		{entry: "[::1]:8080/app", host: "::1", port: "8080"},
+		{entry: "[2001:db8::1]", host: "2001:db8::1"},
+		{entry: "DOCS.Example.com", host: "docs.example.com"},
+		{entry: "ftp://files.example.com", err: "scheme ftp is not supported"},
+		{entry: "file:///etc/passwd", err: "scheme file is not supported"},
+		{entry: "user@example.com", err: "without user, query or fragment"},
+		{entry: "example.com/a?b=c", err: "without user, query or fragment"},
+		{entry: "example.com:http", err: `port "http" is not a number`},
+		{entry: "example.com:", err: `port "" is not a number`},
+		{entry: ":8080", err: "has no host"},
+	} {
+		rule, err := parseNavigationRule(tc.entry)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.entry, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.entry, err)
+			continue
+		}
+		if rule.scheme != tc.scheme || rule.port != tc.port || !rule.host.MatchString(tc.host) {
+			t.Errorf("%s: scheme %q, port %q, host %s does not match %s", tc.entry, rule.scheme, rule.port, rule.host, tc.host)
+		}
+	}
+}
+
+func TestNavigationFilterCheck(t *testing.T) {
+	target, _ := url.Parse("https://app.example.com/login")
+	guard, err := newOriginGuard(target, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := []string{"*.docs.example.org", "https://help.example.net/kb/*", "intranet.example.com:8443", "[::1]:8080"}
+	deny := []string{"app.example.com/admin/*", "*.docs.example.org/private/*"}
+	filter, err := newNavigationFilter(allow, deny, guard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		url    string
+		reason string
+	}{
+		// The origins of url and idpDomains are always allowed
+		{url: "https://app.example.com/home"},
+		{url: "http://app.example.com/home", reason: "not in navigationAllow"},
+		// *.docs.example.org matches the subdomains, not docs.example.org itself
+		{url: "https://a.docs.example.org/x"},
+		{url: "http://a.b.docs.example.org/"},
+		{url: "https://docs.example.org/x", reason: "not in navigationAllow"},
+		{url: "https://evildocs.example.org/", reason: "not in navigationAllow"},
+		// Scheme and path
+		{url: "https://help.example.net/kb/42?q=1#top"},
+		{url: "http://help.example.net/kb/42", reason: "not in navigationAllow"},
+		{url: "https://help.example.net/other", reason: "not in navigationAllow"},
+		// Ports, the default port of the scheme if none is given
+		{url: "https://intranet.example.com:8443/"},
+		{url: "https://intranet.example.com/", reason: "not in navigationAllow"},
+		{url: "http://[::1]:8080/"},
+		{url: "http://[::1]:8081/", reason: "not in navigationAllow"},
+		// navigationDeny takes precedence, over the allowed origins too
+		{url: "https://app.example.com/admin/users", reason: "denied by app.example.com/admin/*"},
+		{url: "https://a.docs.example.org/private/x", reason: "denied by *.docs.example.org/private/*"},
+		// Other schemes, except the pages of new windows and the blocked page
+		{url: "file:///C:/Windows/win.ini", reason: "scheme file is not allowed"},
+		{url: "chrome://settings/", reason: "scheme chrome is not allowed"},
+		{url: "chrome://downloads/", reason: "scheme chrome is not allowed"},
+		{url: "javascript:alert(1)", reason: "scheme javascript is not allowed"},
+		{url: "data:text/html,<h1>hello</h1>", reason: "scheme data is not allowed"},
+		{url: "about:blank"},
+		{url: "chrome://newtab/"},
+		{url: blockedPageURL("chrome://settings/")},
+	} {
+		if reason := filter.check(tc.url); reason != tc.reason {
+			t.Errorf("%s: reason %q, want %q", tc.url, reason, tc.reason)
+		}
+	}
+
+	// Without navigationAllow everything but navigationDeny is allowed, other schemes are blocked still
+	filter, err = newNavigationFilter(nil, deny, guard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		url    string
+		reason string
+	}{
+		{url: "https://www.example.com/"},
+		{url: "https://app.example.com/admin/", reason: "denied by app.example.com/admin/*"},
+		{url: "file:///etc/passwd", reason: "scheme file is not allowed"},
+	} {
+		if reason := filter.check(tc.url); reason != tc.reason {
+			t.Errorf("%s: reason %q, want %q", tc.url, reason, tc.reason)
+		}
+	}
+}
+
+func TestNavigationFilterBrowser(t *testing.T) {
+	launch, dataDir := testBrowserLaunch(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>app</title>"))
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	guard, err := newOriginGuard(target, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newNavigationFilter([]string{"docs.example.com"}, nil, guard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dataDir, "local.html")
+	if err := os.WriteFile(file, []byte("<title>local</title>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	var title string
+	if err := chromedp.Run(ctx, interceptRequests([]requestFilter{filter}, "test"), chromedp.Navigate(srv.URL), chromedp.Title(&title)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "app" {
+		t.Errorf("allowed origin: title %q", title)
+	}
+	// A file is paused by the Fetch domain, a data: URL is not and is replaced by the blocked page once shown
+	for _, blocked := range []string{"file://" + filepath.ToSlash(file), "data:text/html,<title>data</title>"} {
+		if err := chromedp.Run(ctx, chromedp.Navigate(blocked)); err != nil {
+			t.Fatal(err)
+		}
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(100 * time.Millisecond) {
+			if err := chromedp.Run(ctx, chromedp.Title(&title)); err != nil {
+				t.Fatal(err)
+			}
+			if title == "Page not available" {
+				break
+			}
+		}
+		if title != "Page not available" {
+			t.Errorf("%s: title %q", blocked, title)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+// Origins the credentials may be entered on. Secrets, TOTP codes and values built from secret values are only typed
+// into an element whose document has the origin of url or of an idpDomains entry, and, for https origins, whose
+// certificate was accepted by the browser without errors. The check is repeated right before every secret is typed,
+// so a redirect or a spoofed page can not receive the credentials.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/security"
+	"github.com/chromedp/chromedp"
+)
+
+// Returns the origin of the document of the element, "null" for opaque origins like sandboxed frames
+const elementOriginScript = `function() {
+	const w = this.ownerDocument && this.ownerDocument.defaultView;
+	return w ? w.origin : 'null';
+}`
+
+// originRule is an entry of the allowlist: an origin, or with wildcard every subdomain of host
+type originRule struct {
+	scheme   string
+	host     string
+	port     string // Empty for the default port of scheme
+	wildcard bool
+}
+
+// parseOriginRule parses an idpDomains entry: a host like login.microsoftonline.com, a wildcard like *.okta.com,
+// or an origin like https://sso.example.com:8443. Entries without a scheme are https only.
+func parseOriginRule(entry string) (originRule, error) {
+	raw := entry
+	if !strings.Contains(entry, "://") {
+		raw = "https://" + entry
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return originRule{}, fmt.Errorf("%q is not a host name or an origin", entry)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return originRule{}, fmt.Errorf("%q: scheme %s is not supported, use https", entry, u.Scheme)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return originRule{}, fmt.Errorf("%q must be a host name or an origin without user, path or query", entry)
+	}
+	rule := originRule{scheme: u.Scheme, host: strings.ToLower(u.Hostname())}
+	if strings.HasPrefix(rule.host, "*.") {
+		rule.wildcard = true
+		rule.host = rule.host[2:]
+		if !strings.Contains(rule.host, ".") {
+			return originRule{}, fmt.Errorf("%q is too broad, a wildcard must be followed by a domain with at least two labels like *.example.com", entry)
+		}
+	}
+	if strings.Contains(rule.host, "*") {
+		return originRule{}, fmt.Errorf("%q: a wildcard is only supported as the first label, like *.example.com", entry)
+	}
+	if port := u.Port(); !((u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80")) {
+		rule.port = port
+	}
+	return rule, nil
+}
+
+// matches reports whether the origin u is allowed by the rule
+func (r originRule) matches(u *url.URL) bool {
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+	if scheme != r.scheme || port != r.port {
+		return false
+	}
+	if r.wildcard {
+		return strings.HasSuffix(host, "."+r.host)
+	}
+	return host == r.host
+}
+
+func (r originRule) String() string {
+	host := r.host
+	if r.wildcard {
+		host = "*." + host
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if r.port != "" {
+		host += ":" + r.port
+	}
+	return r.scheme + "://" + host
+}
+
+// originGuard checks the origin and the TLS state of the page before credentials are entered
+type originGuard struct {
+	rules    []originRule
+	insecure bool // browser_insecure: the browser ignores certificate errors, so the certificate can not be verified
+
+	mu     sync.Mutex
+	states map[string]security.State // Security state of the last document loaded from each origin
+}
+
+// newOriginGuard builds the allowlist from the origin of target and the idpDomains entries
+func newOriginGuard(target *url.URL, idpDomains []string, insecure bool) (*originGuard, error) {
+	g := &originGuard{insecure: insecure, states: map[string]security.State{}}
+	rule, err := parseOriginRule(originOf(target))
+	if err != nil {
+		return nil, err
+	}
+	g.rules = append(g.rules, rule)
+	for _, entry := range idpDomains {
+		rule, err := parseOriginRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("idpDomains: %w", err)
+		}
+		g.rules = append(g.rules, rule)
+	}
+	return g, nil
+}
+
+func (g *originGuard) String() string {
+	origins := make([]string, len(g.rules))
+	for i, r := range g.rules {
+		origins[i] = r.String()
+	}
+	return strings.Join(origins, ", ")
+}
+
+// allowed returns the rule allowing origin, false if none does
+func (g *originGuard) allowed(origin string) (originRule, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return originRule{}, false
+	}
+	for _, r := range g.rules {
+		if r.matches(u) {
+			return r, true
+		}
+	}
+	return originRule{}, false
+}
+
+// fetchPatterns returns the Fetch domain patterns of the requests of the allowed origins
+func (g *originGuard) fetchPatterns() []*fetch.RequestPattern {
+	patterns := make([]*fetch.RequestPattern, len(g.rules))
+	for i, r := range g.rules {
+		patterns[i] = &fetch.RequestPattern{URLPattern: r.String() + "/*"}
+	}
+	return patterns
+}
+
+// start records the security state of the documents loaded by the browser, it must precede the first navigation
+func (g *originGuard) start(uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		chromedp.ListenTarget(context.WithoutCancel(ctx), func(ev any) {
+			resp, ok := ev.(*network.EventResponseReceived)
+			if !ok || resp.Type != network.ResourceTypeDocument {
+				return
+			}
+			u, err := url.Parse(resp.Response.URL)
+			if err != nil || u.Host == "" {
+				return
+			}
+			g.mu.Lock()
+			g.states[originOf(u)] = resp.Response.SecurityState
+			g.mu.Unlock()
+		})
+		slog.Debug("[credentialOrigin] Credentials are only entered on the allowed origins", "allowed_origins", g.String(), "sessionid", uuid)
+		return network.Enable().Do(ctx)
+	})
+}
+
+// state returns the security state of the last document loaded from origin, false if none was loaded
+func (g *originGuard) state(origin string) (security.State, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	state, seen := g.states[origin]
+	return state, seen
+}
+
+// originMismatchError reports credentials which were not entered as the page was not on an allowed origin or its TLS state was not secure
+type originMismatchError struct {
+	origin   string
+	selector string
+	reason   string
+}
+
+func (e *originMismatchError) Error() string {
+	return fmt.Sprintf("credentials withheld from %s at %s: %s", e.origin, e.selector, e.reason)
+}
+
+// verify checks the origin of the document of node and the TLS state of that origin
+func (g *originGuard) verify(ctx context.Context, node *cdp.Node, selector string, uuid string) error {
+	obj, err := dom.ResolveNode().WithNodeID(node.NodeID).Do(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := runtime.ReleaseObject(obj.ObjectID).Do(ctx); err != nil {
+			slog.Debug("[credentialOrigin] Cannot release element", "error", err.Error(), "sessionid", uuid)
+		}
+	}()
+	res, exc, err := runtime.CallFunctionOn(elementOriginScript).WithObjectID(obj.ObjectID).WithReturnByValue(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exc != nil {
+		return fmt.Errorf("cannot determine the origin of %s: %s", selector, exc.Text)
+	}
+	var origin string
+	if err := json.Unmarshal(res.Value, &origin); err != nil {
+		return fmt.Errorf("cannot determine the origin of %s: %w", selector, err)
+	}
+
+	rule, ok := g.allowed(origin)
+	reason := ""
+	switch {
+	case !ok:
+		reason = "origin is not allowed, allowed origins: " + g.String()
+	case rule.scheme == "https" && !g.insecure:
+		state, seen := g.state(origin)
+		if !seen {
+			state = security.StateUnknown
+		}
+		if state != security.StateSecure {
+			reason = "TLS state of the page is " + string(state) + ", not secure"
+		}
+	}
+	if reason != "" {
+		slog.Error("[credentialOrigin] Credentials withheld", "event", "credential_origin_mismatch", "origin", origin, "selector", selector, "reason", reason, "sessionid", uuid)
+		return &originMismatchError{origin: origin, selector: selector, reason: reason}
+	}
+	slog.Debug("[credentialOrigin] Origin verified", "origin", origin, "selector", selector, "sessionid", uuid)
+	return nil
+}
+
+// sendKeys verifies the element of sel and types text into that very element
+func (g *originGuard) sendKeys(sel elementSelector, text string, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var nodes []*cdp.Node
+		if err := chromedp.Nodes(sel.query, &nodes, sel.option, chromedp.NodeVisible).Do(ctx); err != nil {
+			return err
+		}
+		if err := g.verify(ctx, nodes[0], sel.query, uuid); err != nil {
+			return err
+		}
+		return chromedp.SendKeys([]cdp.NodeID{nodes[0].NodeID}, text, chromedp.ByNodeID).Do(ctx)
+	})
+}
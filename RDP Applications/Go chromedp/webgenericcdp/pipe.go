@@ -0,0 +1,285 @@
+package main
+
+// DevTools protocol over pipes. chromedp's ExecAllocator starts the browser with --remote-debugging-port=0, and the
+// port on localhost can be discovered and attached to by any other process or user of a shared RDP host, to take over
+// the authenticated browser or read the typed password. pipeAllocator starts the browser with --remote-debugging-pipe:
+// the protocol is spoken over two anonymous pipes inherited only by the browser, the browser opens no port.
+//
+// chromedp only speaks the protocol over a websocket it dials itself, without a way to pass it a dialer or a
+// connection, so the messages are relayed by a bridge between the websocket frames and the null terminated messages of
+// the pipes. The bridge opens a short-lived port on the loopback interface: it listens only until chromedp is connected,
+// within the dial timeout of chromedp, and only accepts the handshake on the secret path of the URL passed to chromedp,
+// so another process connecting in the meantime can not attach to the browser.
+//
+// On Windows the handles of the pipes are passed with --remote-debugging-io-pipes. A browser which does not support the
+// switch does not answer on the pipes: the allocation fails after pipeAnswerTimeout, use remoteDebugging=port then.
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cdpbrowser "github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	pipeShutdownGrace    = 5 * time.Second  // Time the browser is given to exit on its own once its browser context is cancelled, before it is killed
+	pipeHandshakeTimeout = 5 * time.Second  // Time limit of a websocket handshake with the bridge
+	pipeAnswerTimeout    = 30 * time.Second // Time the browser is given to answer the first command on the pipe
+)
+
+// pipeAllocator is a chromedp.Allocator starting the browser of launch with a debugging pipe
+type pipeAllocator struct {
+	launch  *browserLaunch
+	uuid    string
+	cancel  context.CancelFunc // Cancels the browser contexts when the connection to the browser is lost
+	wg      sync.WaitGroup
+	process *os.Process // Browser process, nil until it is started
+}
+
+// newPipeAllocator returns the parent context of the browser contexts and the option selecting the allocator
+func newPipeAllocator(parent context.Context, launch *browserLaunch, uuid string) (context.Context, chromedp.ContextOption) {
+	ctx, cancel := context.WithCancel(parent)
+	a := &pipeAllocator{launch: launch, uuid: uuid, cancel: cancel}
+	return ctx, func(c *chromedp.Context) { c.Allocator = a }
+}
+
+// Allocate satisfies the chromedp.Allocator interface
+func (a *pipeAllocator) Allocate(ctx context.Context, opts ...chromedp.BrowserOption) (*chromedp.Browser, error) {
+	// commands: written by webgenericcdp, read by the browser; events: responses and events written by the browser
+	commandsRead, commandsWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	eventsRead, eventsWrite, err := os.Pipe()
+	if err != nil {
+		commandsRead.Close()
+		commandsWrite.Close()
+		return nil, err
+	}
+	closeBrowserEnds := func() {
+		commandsRead.Close()
+		eventsWrite.Close()
+	}
+
+	args := a.launch.args()
+	dataDir, ok := a.launch.flags["user-data-dir"].(string)
+	removeDir := false
+	if !ok {
+		if dataDir, err = os.MkdirTemp("", "webgenericcdp-runner-"); err != nil {
+			closeBrowserEnds()
+			return nil, err
+		}
+		args = append(args, "--user-data-dir="+dataDir)
+		removeDir = true
+	}
+	if _, ok := a.launch.flags["no-sandbox"]; !ok && os.Getuid() == 0 {
+		// Chrome does not start as root without --no-sandbox, like in chromedp
+		args = append(args, "--no-sandbox")
+	}
+	cmd := exec.Command(a.launch.path())
+	pipeArgs, err := inheritPipes(cmd, commandsRead, eventsWrite)
+	if err != nil {
+		closeBrowserEnds()
+		return nil, fmt.Errorf("cannot pass the debugging pipes to the browser: %w", err)
+	}
+	// The first page is blank instead of the welcome page
+	cmd.Args = append(append(append(cmd.Args, args...), pipeArgs...), "about:blank")
+	slog.Debug("[pipe] Starting browser with a debugging pipe", "path", cmd.Path, "sessionid", a.uuid)
+	err = cmd.Start()
+	// The browser holds its own copies of its ends, the pipes are broken when it exits
+	closeBrowserEnds()
+	if err != nil {
+		commandsWrite.Close()
+		eventsRead.Close()
+		if removeDir {
+			os.RemoveAll(dataDir)
+		}
+		return nil, err
+	}
+
+	a.process = cmd.Process
+	exited := make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			slog.Debug("[pipe] Browser exited", "error", err.Error(), "sessionid", a.uuid)
+		}
+		close(exited)
+		eventsRead.Close()
+		if removeDir {
+			os.RemoveAll(dataDir)
+		}
+		a.wg.Done()
+	}()
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+		// The browser closes when its debugging pipe is closed, if it does not it is killed
+		commandsWrite.Close()
+		select {
+		case <-exited:
+		case <-time.After(pipeShutdownGrace):
+			slog.Debug("[pipe] Killing browser", "sessionid", a.uuid)
+			cmd.Process.Kill()
+		}
+	}()
+
+	bridge := &pipeBridge{commands: commandsWrite, events: eventsRead, uuid: a.uuid}
+	wsURL, listener, err := bridge.listen()
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("cannot relay the debugging pipe: %w", err)
+	}
+	browser, err := chromedp.NewBrowser(ctx, wsURL, opts...)
+	// chromedp is connected or gave up, no other connection is accepted
+	listener.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	// The bridge accepts chromedp without the browser, a browser not reading the pipes is only noticed by its answers
+	actx, cancel := context.WithTimeout(ctx, pipeAnswerTimeout)
+	defer cancel()
+	if _, _, _, _, _, err := cdpbrowser.GetVersion().Do(cdp.WithExecutor(actx, browser)); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("the browser does not answer on the debugging pipe, use remoteDebugging=port if it does not support --remote-debugging-pipe: %w", err)
+	}
+	go func() {
+		// Like chromedp's allocators, stop every action of the browser once it is gone
+		<-browser.LostConnection
+		a.cancel()
+	}()
+	return browser, nil
+}
+
+// Wait satisfies the chromedp.Allocator interface
+func (a *pipeAllocator) Wait() {
+	a.wg.Wait()
+}
+
+// pipeBridge relays the DevTools messages between a websocket connection and the debugging pipes of a browser
+type pipeBridge struct {
+	commands io.WriteCloser
+	events   io.Reader
+	uuid     string
+}
+
+// lockedWriter serializes the frames written to a websocket connection
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// listen returns the websocket URL of the bridge on a loopback port and its listener. The first handshake on the secret
+// path of the URL is relayed to the browser, every other connection is rejected. The listener must be closed once
+// chromedp is connected.
+func (b *pipeBridge) listen() (string, net.Listener, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	path := []byte("/devtools/browser/" + hex.EncodeToString(secret))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	var claimed atomic.Bool
+	upgrader := ws.Upgrader{OnRequest: func(uri []byte) error {
+		if subtle.ConstantTimeCompare(uri, path) != 1 || !claimed.CompareAndSwap(false, true) {
+			return ws.RejectConnectionError(ws.RejectionStatus(http.StatusForbidden))
+		}
+		return nil
+	}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.SetDeadline(time.Now().Add(pipeHandshakeTimeout))
+				if _, err := upgrader.Upgrade(conn); err != nil {
+					var rejected *ws.ConnectionRejectedError
+					if errors.As(err, &rejected) {
+						slog.Warn("[pipe] Connection to the debugging bridge rejected", "remote", conn.RemoteAddr().String(), "sessionid", b.uuid)
+					} else {
+						slog.Debug("[pipe] Websocket handshake failed", "remote", conn.RemoteAddr().String(), "error", err.Error(), "sessionid", b.uuid)
+					}
+					conn.Close()
+					return
+				}
+				listener.Close()
+				conn.SetDeadline(time.Time{})
+				b.serve(conn)
+			}()
+		}
+	}()
+	return "ws://" + listener.Addr().String() + string(path), listener, nil
+}
+
+// serve relays the messages between the websocket connection conn of chromedp and the browser until either side is closed
+func (b *pipeBridge) serve(conn net.Conn) {
+	defer conn.Close()
+	var mu sync.Mutex
+	go func() {
+		// Closing conn stops the loop below once the browser is gone
+		defer conn.Close()
+		r := bufio.NewReader(b.events)
+		for {
+			msg, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			err = wsutil.WriteServerText(conn, msg[:len(msg)-1])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{conn, lockedWriter{mu: &mu, w: conn}}
+	for {
+		data, op, err := wsutil.ReadClientData(rw)
+		if err != nil {
+			return
+		}
+		if op != ws.OpText {
+			continue
+		}
+		if _, err := b.commands.Write(append(data, 0)); err != nil {
+			slog.Debug("[pipe] Cannot write to the browser", "error", err.Error(), "sessionid", b.uuid)
+			return
+		}
+	}
+}
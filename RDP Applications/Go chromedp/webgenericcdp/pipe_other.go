@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// inheritPipes passes the pipes to the browser as the file descriptors 3 (commands) and 4 (events) of --remote-debugging-pipe
+func inheritPipes(cmd *exec.Cmd, commands *os.File, events *os.File) ([]string, error) {
+	cmd.ExtraFiles = []*os.File{commands, events}
+	return []string{"--remote-debugging-pipe"}, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/gobwas/ws"
+)
+
+// Time the sockets of the browser are watched in TestPipeAllocator
+const pipeSocketWatch = 3 * time.Second
+
+// browserProcesses returns the processes whose command line contains the profile dataDir of the browser. The
+// port may be opened by another process than the browser process, like the network service.
+func browserProcesses(t *testing.T, dataDir string) []int {
+	t.Helper()
+	var pids []int
+	switch runtime.GOOS {
+	case "linux":
+		entries, err := os.ReadDir("/proc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			pid, err := strconv.Atoi(e.Name())
+			if err != nil {
+				continue
+			}
+			if cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline")); err == nil && strings.Contains(string(cmdline), dataDir) {
+				pids = append(pids, pid)
+			}
+		}
+	case "windows":
+		out, err := exec.Command("powershell", "-NoProfile", "-Command",
+			"Get-CimInstance Win32_Process | Where-Object { $_.CommandLine -and $_.CommandLine.Contains($env:WEBGENERICCDP_TEST_PROFILE) } | ForEach-Object { $_.ProcessId }").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids = parsePids(string(out))
+	case "darwin":
+		out, err := exec.Command("ps", "-axo", "pid=,command=").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, dataDir) {
+				pids = append(pids, parsePids(line)[:1]...)
+			}
+		}
+	default:
+		t.Skip("processes are not listed on " + runtime.GOOS)
+	}
+	return pids
+}
+
+// parsePids returns the numbers at the start of the lines of text
+func parsePids(text string) []int {
+	var pids []int
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if pid, err := strconv.Atoi(fields[0]); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	return pids
+}
+
+// listeningSockets returns the listening TCP sockets of the processes pids
+func listeningSockets(t *testing.T, pids ...int) []string {
+	t.Helper()
+	processes := map[string]bool{}
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+		processes[list[i]] = true
+	}
+	var sockets []string
+	switch runtime.GOOS {
+	case "linux":
+		listening := map[string]bool{}
+		for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+			data, err := os.ReadFile(table)
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(data), "\n")[1:] {
+				// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+				if fields := strings.Fields(line); len(fields) > 9 && fields[3] == "0A" {
+					listening[fields[9]] = true
+				}
+			}
+		}
+		for pid := range processes {
+			fds, _ := os.ReadDir(filepath.Join("/proc", pid, "fd"))
+			for _, fd := range fds {
+				link, _ := os.Readlink(filepath.Join("/proc", pid, "fd", fd.Name()))
+				if inode, ok := strings.CutPrefix(link, "socket:["); ok && listening[strings.TrimSuffix(inode, "]")] {
+					sockets = append(sockets, "pid "+pid+" inode "+strings.TrimSuffix(inode, "]"))
+				}
+			}
+		}
+	case "windows":
+		out, err := exec.Command("netstat", "-ano").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			// Proto Local-Address Foreign-Address State PID
+			if fields := strings.Fields(line); len(fields) == 5 && fields[3] == "LISTENING" && processes[fields[4]] {
+				sockets = append(sockets, strings.TrimSpace(line))
+			}
+		}
+	case "darwin":
+		// lsof exits with 1 if no socket matches
+		out, _ := exec.Command("lsof", "-nP", "-a", "-iTCP", "-sTCP:LISTEN", "-p", strings.Join(list, ",")).Output()
+		if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) > 1 {
+			sockets = append(sockets, lines[1:]...)
+		}
+	default:
+		t.Skip("listening sockets are not read on " + runtime.GOOS)
+	}
+	return sockets
+}
+
+func TestPipeBridgeListen(t *testing.T) {
+	events, eventsWrite := io.Pipe()
+	defer eventsWrite.Close()
+	commands, commandsWrite := io.Pipe()
+	defer commands.Close()
+	bridge := &pipeBridge{commands: commandsWrite, events: events, uuid: "test"}
+	wsURL, listener, err := bridge.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if !strings.HasPrefix(wsURL, "ws://127.0.0.1:") {
+		t.Errorf("URL %s is not on loopback", wsURL)
+	}
+
+	ctx := context.Background()
+	if _, _, _, err := ws.Dial(ctx, wsURL[:strings.LastIndexByte(wsURL, '/')]+"/guessed"); err == nil {
+		t.Error("handshake on another path accepted")
+	}
+	conn, _, _, err := ws.Dial(ctx, wsURL)
+	if err != nil {
+		t.Fatalf("handshake of chromedp: %v", err)
+	}
+	defer conn.Close()
+	if conn, _, _, err := ws.Dial(ctx, wsURL); err == nil {
+		conn.Close()
+		t.Error("second handshake accepted")
+	}
+}
+
+// testBrowserLaunch returns the launch of the browser of WEBGENERICCDP_TEST_BROWSER with a temporary profile, removed
+// by the cleanup, or skips the test without browser
+func testBrowserLaunch(t *testing.T) (launch *browserLaunch, dataDir string) {
+	t.Helper()
+	launch = newBrowserLaunch()
+	launch.execPath = os.Getenv("WEBGENERICCDP_TEST_BROWSER")
+	if _, err := exec.LookPath(launch.path()); err != nil {
+		t.Skip("no browser found, set WEBGENERICCDP_TEST_BROWSER to its path")
+	}
+	// The processes of the browser may still write into the profile for a moment after the browser exits
+	dataDir, err := os.MkdirTemp("", "webgenericcdp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeTempProfile(dataDir, "test") })
+	t.Setenv("WEBGENERICCDP_TEST_PROFILE", dataDir)
+	launch.flag("user-data-dir", dataDir)
+	return launch, dataDir
+}
+
+func TestPipeAllocator(t *testing.T) {
+	launch, dataDir := testBrowserLaunch(t)
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	var title string
+	if err := chromedp.Run(ctx, chromedp.Navigate("data:text/html,<title>pipe</title>"), chromedp.Title(&title)); err != nil {
+		t.Fatal(err)
+	}
+	if title != "pipe" {
+		t.Errorf("title %q", title)
+	}
+	// The bridge is closed once chromedp is connected, neither webgenericcdp nor the browser listen. The browser
+	// would open its debugging port shortly after showing the first page, so the sockets are read for a while.
+	browser := chromedp.FromContext(ctx).Allocator.(*pipeAllocator).process
+	var sockets []string
+	for start := time.Now(); len(sockets) == 0 && time.Since(start) < pipeSocketWatch; time.Sleep(100 * time.Millisecond) {
+		sockets = listeningSockets(t, append(browserProcesses(t, dataDir), os.Getpid(), browser.Pid)...)
+	}
+	if len(sockets) > 0 {
+		t.Errorf("listening sockets: %v", sockets)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "DevToolsActivePort")); !os.IsNotExist(err) {
+		t.Errorf("DevToolsActivePort: %v", err)
+	}
+}
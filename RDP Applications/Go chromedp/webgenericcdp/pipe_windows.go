@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// inheritPipes passes the handles of the pipes to the browser, which reads them from --remote-debugging-io-pipes.
+// Only these handles are inherited, besides the standard ones.
+func inheritPipes(cmd *exec.Cmd, commands *os.File, events *os.File) ([]string, error) {
+	in, out := syscall.Handle(commands.Fd()), syscall.Handle(events.Fd())
+	for _, h := range []syscall.Handle{in, out} {
+		if err := syscall.SetHandleInformation(h, syscall.HANDLE_FLAG_INHERIT, syscall.HANDLE_FLAG_INHERIT); err != nil {
+			return nil, err
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{AdditionalInheritedHandles: []syscall.Handle{in, out}}
+	return []string{"--remote-debugging-pipe", fmt.Sprintf("--remote-debugging-io-pipes=%d,%d", in, out)}, nil
+}
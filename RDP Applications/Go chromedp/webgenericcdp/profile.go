@@ -0,0 +1,147 @@
+package main
+
+// Locked-down browser profile. The preferences behind the policies which keep the injected credentials in the page,
+// like DeveloperToolsAvailability and PasswordManagerEnabled, are written into the profile before the browser starts,
+// and the values in effect are read back from chrome://prefs-internals before the login. If a value can not be written
+// or the browser does not apply it, for example as a policy of the machine overrides it, the login is not performed.
+// Only the profile is changed, the user can still change the preferences in the settings of the browser.
+//
+// In an incognito window (browser_incognito=true) the preferences are inherited from the profile, but they are not
+// verified: chrome://prefs-internals has not been verified to show them there.
+//
+// A profile created by webgenericcdp in the temporary folder is removed once the browser exits.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Page of the browser showing the preferences in effect as JSON
+const preferencesPage = "chrome://prefs-internals"
+
+// Time limit of reading the preferences in effect
+const lockdownVerifyTimeout = 20 * time.Second
+
+// lockdownPreference is a preference of the profile, with the policy setting the same preference
+type lockdownPreference struct {
+	path   string // Dotted path within the Preferences file
+	value  interface{}
+	policy string
+}
+
+var lockdownPreferences = []lockdownPreference{
+	{path: "devtools.availability", value: 2, policy: "DeveloperToolsAvailability"}, // 2: disallowed everywhere
+	{path: "credentials_enable_service", value: false, policy: "PasswordManagerEnabled"},
+	{path: "credentials_enable_autosignin", value: false, policy: "PasswordManagerEnabled"},
+	{path: "autofill.profile_enabled", value: false, policy: "AutofillAddressEnabled"},
+	{path: "autofill.credit_card_enabled", value: false, policy: "AutofillCreditCardEnabled"},
+	{path: "extensions.install.denylist", value: []string{"*"}, policy: "ExtensionInstallBlocklist"},
+	{path: "sync.managed", value: true, policy: "SyncDisabled"},
+}
+
+// Number of attempts of removing the temporary profile, the files may still be open for a moment after the browser exits
+const (
+	removeProfileAttempts = 10
+	removeProfileInterval = 500 * time.Millisecond
+)
+
+// writeLockdownPreferences sets the lockdown preferences in the Preferences file of the default profile of userDataDir,
+// keeping the other preferences of an existing profile
+func writeLockdownPreferences(userDataDir string) error {
+	path := filepath.Join(userDataDir, "Default", "Preferences")
+	prefs := map[string]interface{}{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		// Numbers are kept as they are, timestamps and IDs of the profile do not fit into a float64
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&prefs); err != nil {
+			return fmt.Errorf("cannot parse %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	for _, p := range lockdownPreferences {
+		setPreference(prefs, strings.Split(p.path, "."), p.value)
+	}
+	if data, err = json.Marshal(prefs); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// removeTempProfile removes the profile created in the temporary folder, nothing if dir is ""
+func removeTempProfile(dir string, uuid string) {
+	if dir == "" {
+		return
+	}
+	var err error
+	for i := 0; i < removeProfileAttempts; i++ {
+		if err = os.RemoveAll(dir); err == nil {
+			slog.Debug("[lockdown] Temporary profile removed", "UserDataDir", dir, "sessionid", uuid)
+			return
+		}
+		time.Sleep(removeProfileInterval)
+	}
+	slog.Warn("[lockdown] Cannot remove the temporary profile: "+err.Error(), "UserDataDir", dir, "sessionid", uuid)
+}
+
+// setPreference sets the value at the path of nested objects, replacing values which are not objects on the way
+func setPreference(prefs map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		prefs[path[0]] = value
+		return
+	}
+	child, ok := prefs[path[0]].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{}
+		prefs[path[0]] = child
+	}
+	setPreference(child, path[1:], value)
+}
+
+// verifyLockdown reads the preferences in effect in the current tab and returns an error listing the ones which differ
+func verifyLockdown(ctx context.Context, uuid string) error {
+	ctx, cancel := context.WithTimeout(ctx, lockdownVerifyTimeout)
+	defer cancel()
+	var text string
+	if err := chromedp.Run(ctx, chromedp.Navigate(preferencesPage), chromedp.Evaluate(`document.body ? document.body.innerText : ""`, &text)); err != nil {
+		return fmt.Errorf("cannot read the preferences in effect from %s: %w", preferencesPage, err)
+	}
+	var effective map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &effective); err != nil {
+		return fmt.Errorf("cannot parse the preferences in effect from %s: %w", preferencesPage, err)
+	}
+	var errs []error
+	for _, p := range lockdownPreferences {
+		v, lerr := lookupValue(effective, p.path)
+		want, _ := json.Marshal(p.value)
+		var expected interface{}
+		_ = json.Unmarshal(want, &expected)
+		if lerr != nil {
+			errs = append(errs, fmt.Errorf("preference %s (policy %s) is not set, expected %s", p.path, p.policy, want))
+			continue
+		}
+		if !reflect.DeepEqual(v, expected) {
+			errs = append(errs, fmt.Errorf("preference %s (policy %s) is %s instead of %s", p.path, p.policy, formatValue(v), want))
+			continue
+		}
+		slog.Debug("[lockdown] Preference in effect", "preference", p.path, "value", formatValue(v), "sessionid", uuid)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteLockdownPreferences(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Default", "Preferences")
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err := os.WriteFile(path, []byte(`{"devtools":{"availability":1,"preferences":{"x":"y"}},"sync":true,"profile":{"last_engagement_time":13356913474123456789}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLockdownPreferences(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"last_engagement_time":13356913474123456789`) {
+		t.Errorf("number of the profile changed: %s", data)
+	}
+	var prefs map[string]interface{}
+	if err := json.Unmarshal(data, &prefs); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]interface{}{
+		"devtools.availability":        2.0,
+		"devtools.preferences.x":       "y",
+		"credentials_enable_service":   false,
+		"extensions.install.denylist":  []interface{}{"*"},
+		"sync.managed":                 true,
+		"autofill.credit_card_enabled": false,
+	} {
+		if v, err := lookupValue(prefs, path); err != nil || !reflect.DeepEqual(v, want) {
+			t.Errorf("%s: %v (%v), want %v", path, v, err, want)
+		}
+	}
+
+	removeTempProfile(dir, "test")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("profile not removed: %v", err)
+	}
+}
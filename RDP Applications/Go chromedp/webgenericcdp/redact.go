@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replaces the secrets in the log
+const redactedText = "<hidden>"
+
+// Secrets shorter than this, like PINs, are only redacted where they are not part of a longer word or number:
+// replacing every occurrence of a one or two character string would make the log unreadable
+const minSecretLength = 4
+
+// Names of the values received from Safeguard which are always secrets, matched case-insensitively as part of the key name
+var sensitiveKeyParts = []string{"password", "passphrase", "secret", "token", "seed", "otp", "privatekey", "apikey"}
+
+// secretSet collects the secrets known so far: values received from Safeguard, TOTP codes and values of secret actions.
+// The log handler replaces them with redactedText everywhere.
+type secretSet struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	short    []string // Forms of the secrets shorter than minSecretLength, longest first
+	replacer *strings.Replacer
+}
+
+// secrets is used by every log line of webgenericcdp through redactingHandler
+var secrets = &secretSet{values: map[string]bool{}}
+
+// add registers secrets, together with their JSON and URL encoded forms, as they appear in CDP messages and URLs
+func (s *secretSet) add(values ...string) {
+	if s.addForms(values) {
+		slog.Warn("A secret is shorter than " + strconv.Itoa(minSecretLength) + " characters, it is only hidden in the log where it is not part of a longer word or number")
+	}
+}
+
+// addForms registers the forms of values and reports whether a new short secret was added
+func (s *secretSet) addForms(values []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, short := false, false
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		encoded, _ := json.Marshal(value)
+		for _, form := range []string{value, string(encoded[1 : len(encoded)-1]), url.QueryEscape(value), url.PathEscape(value), url.User(value).String()} {
+			if s.values[form] {
+				continue
+			}
+			s.values[form] = true
+			if len([]rune(form)) < minSecretLength {
+				s.short = append(s.short, form)
+				short = true
+				continue
+			}
+			changed = true
+		}
+	}
+	sort.Slice(s.short, func(i, j int) bool { return len(s.short[i]) > len(s.short[j]) })
+	if !changed {
+		return short
+	}
+	// Longer secrets first, so that a secret containing another one is replaced as a whole
+	forms := make([]string, 0, len(s.values))
+	for form := range s.values {
+		if len([]rune(form)) >= minSecretLength {
+			forms = append(forms, form)
+		}
+	}
+	sort.Slice(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+	pairs := make([]string, 0, 2*len(forms))
+	for _, form := range forms {
+		pairs = append(pairs, form, redactedText)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+	return short
+}
+
+// addFromStdin registers the secrets of the values received from Safeguard: the password, the TOTP codes
+// and the values whose key is sensitive by its name or listed in sensitiveKeys. Objects, also in JSON text, are
+// searched for sensitive keys, like the apiKey of {"tenantId":"...","apiKey":"..."}.
+func (s *secretSet) addFromStdin(launcherStdin map[string]interface{}, sensitiveKeys []string) {
+	for key, value := range launcherStdin {
+		if value == nil {
+			continue
+		}
+		if !isSensitiveKey(key, sensitiveKeys) {
+			if obj, ok := decodeJSONText(value).(map[string]interface{}); ok {
+				s.addFromStdin(obj, sensitiveKeys)
+			}
+			continue
+		}
+		text := formatValue(value)
+		s.add(text)
+		if codes, err := parseTOTPCodes(text); err == nil {
+			for _, c := range codes {
+				s.add(c.code)
+			}
+		}
+	}
+}
+
+func isSensitiveKey(key string, sensitiveKeys []string) bool {
+	for _, k := range sensitiveKeys {
+		if strings.EqualFold(key, k) {
+			return true
+		}
+	}
+	lower := strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns text with every known secret replaced
+func (s *secretSet) redact(text string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.replacer != nil {
+		text = s.replacer.Replace(text)
+	}
+	for _, form := range s.short {
+		text = redactStandalone(text, form)
+	}
+	return text
+}
+
+// redactStandalone replaces the occurrences of secret in text which are not preceded or followed by a letter or a digit
+func redactStandalone(text string, secret string) string {
+	var b strings.Builder
+	written := 0
+	for from := 0; ; {
+		j := strings.Index(text[from:], secret)
+		if j < 0 {
+			break
+		}
+		i := from + j
+		end := i + len(secret)
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if isWordRune(before) || isWordRune(after) {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			from = i + size
+			continue
+		}
+		b.WriteString(text[written:i])
+		b.WriteString(redactedText)
+		written, from = end, end
+	}
+	b.WriteString(text[written:])
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// redactingHandler wraps a slog.Handler and removes the secrets from the messages and attribute values of every record, regardless of the level
+type redactingHandler struct {
+	next    slog.Handler
+	secrets *secretSet
+}
+
+func newRedactingHandler(next slog.Handler, secrets *secretSet) *redactingHandler {
+	return &redactingHandler{next: next, secrets: secrets}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, h.secrets.redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), secrets: h.secrets}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), secrets: h.secrets}
+}
+
+// redactAttr removes the secrets from the value of an attribute. Values other than strings and groups are redacted in their text form.
+func (h *redactingHandler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.secrets.redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = h.redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		text := fmt.Sprint(v.Any())
+		if redacted := h.secrets.redact(text); redacted != text {
+			return slog.String(a.Key, redacted)
+		}
+		return slog.Attr{Key: a.Key, Value: v}
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+// Parameters of the keyboard events in the CDP messages logged by chromedp. Keys are sent one by one,
+// so a typed secret can not be recognized by its value, the parameters of every key event are hidden instead.
+var keyEventParamsPattern = regexp.MustCompile(`("method":"Input\.(?:dispatchKeyEvent|insertText|imeSetComposition)"[^{}]*"params":)\{[^{}]*\}`)
+
+// chromedpLogf adapts a slog function to the printf-like logging functions of chromedp and hides the parameters of the keyboard events.
+// The record passes through redactingHandler like any other.
+func chromedpLogf(log func(string, ...any)) func(string, ...any) {
+	return func(format string, args ...any) {
+		log("[chromedp] " + keyEventParamsPattern.ReplaceAllString(fmt.Sprintf(format, args...), "${1}{"+redactedText+"}"))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testSecret = `p@ss w/rd"ü&=?`
+
+func newTestSecrets(values ...string) *secretSet {
+	s := &secretSet{values: map[string]bool{}}
+	s.addForms(values)
+	return s
+}
+
+func TestSecretSetRedact(t *testing.T) {
+	s := newTestSecrets(testSecret, "jdoe-token")
+	jsonText, _ := json.Marshal(map[string]string{"password": testSecret})
+	for _, tc := range []struct {
+		name string
+		text string
+	}{
+		{"plain", "password is " + testSecret},
+		{"json", string(jsonText)},
+		{"query", "https://app.example.com/?pw=" + url.QueryEscape(testSecret)},
+		{"path", "https://app.example.com/" + url.PathEscape(testSecret) + "/x"},
+		{"user info", "https://" + url.User(testSecret).String() + "@app.example.com/"},
+		{"user and password", (&url.URL{Scheme: "https", Host: "app.example.com", User: url.UserPassword("jdoe", testSecret)}).String()},
+		{"twice", testSecret + testSecret},
+		{"other secret", "Authorization: Bearer jdoe-token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := s.redact(tc.text)
+			if !strings.Contains(got, redactedText) {
+				t.Errorf("%q: nothing redacted: %q", tc.text, got)
+			}
+			assertNoSecret(t, got, testSecret, "jdoe-token")
+		})
+	}
+	if got := s.redact("nothing to hide"); got != "nothing to hide" {
+		t.Errorf("text without secrets changed: %q", got)
+	}
+}
+
+func TestSecretSetRedactShort(t *testing.T) {
+	s := newTestSecrets("42", "x")
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"pin=42", "pin=" + redactedText},
+		{"42", redactedText},
+		{`{"pin":"42"}`, `{"pin":"` + redactedText + `"}`},
+		{"code 4242 and 142", "code 4242 and 142"},
+		{"box x", "box " + redactedText},
+		{"xx and x.", "xx and " + redactedText + "."},
+		{"", ""},
+	} {
+		if got := s.redact(tc.text); got != tc.want {
+			t.Errorf("redact(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+	if newTestSecrets("").redact("empty") != "empty" {
+		t.Error("empty secret redacted text")
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	s := newTestSecrets(testSecret)
+	var buf bytes.Buffer
+	logger := slog.New(newRedactingHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), s))
+
+	logger.Debug("message "+testSecret, "attr", testSecret)
+	logger.Info("nested", slog.Group("outer", slog.String("a", testSecret), slog.Group("inner", slog.String("b", "x"+testSecret))))
+	logger.With("with", testSecret).WithGroup("grp").Warn("grouped", "c", testSecret)
+	logger.Error("any", "err", errors.New("failed with "+testSecret), "struct", struct{ Password string }{testSecret}, "list", []string{testSecret})
+	logger.Info("stringer", "url", &url.URL{Scheme: "https", Host: "h", RawQuery: "pw=" + url.QueryEscape(testSecret)})
+
+	out := buf.String()
+	if n := strings.Count(out, "\n"); n != 5 {
+		t.Fatalf("%d lines logged, want 5:\n%s", n, out)
+	}
+	assertNoSecret(t, out, testSecret)
+	for _, want := range []string{`"outer":{"a":"<hidden>","inner":{"b":"x<hidden>"}}`, `"with":"<hidden>"`, `"grp":{"c":"<hidden>"}`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestChromedpLogfHidesKeyEvents(t *testing.T) {
+	var logged []string
+	logf := chromedpLogf(func(msg string, args ...any) { logged = append(logged, msg) })
+	for _, method := range []string{"Input.dispatchKeyEvent", "Input.insertText", "Input.imeSetComposition"} {
+		logf("-> %s", fmt.Sprintf(`{"id":7,"sessionId":"S","method":"%s","params":{"type":"keyDown","text":"q","key":"q"}}`, method))
+	}
+	logf("-> %s", `{"id":8,"method":"Page.navigate","params":{"url":"https://app.example.com/"}}`)
+
+	for _, msg := range logged[:3] {
+		if !strings.Contains(msg, `"params":{`+redactedText+`}`) || strings.Contains(msg, `"text":"q"`) {
+			t.Errorf("key event parameters not hidden: %s", msg)
+		}
+	}
+	if !strings.Contains(logged[3], `"url":"https://app.example.com/"`) {
+		t.Errorf("parameters of another method hidden: %s", logged[3])
+	}
+	for _, msg := range logged {
+		if !strings.HasPrefix(msg, "[chromedp] ") {
+			t.Errorf("missing prefix: %s", msg)
+		}
+	}
+}
+
+func TestIsSensitiveKey(t *testing.T) {
+	for _, tc := range []struct {
+		key       string
+		sensitive bool
+	}{
+		{"password", true},
+		{"Target.AccountPassword", true},
+		{"Target.SSHPassphrase", true},
+		{"Target.Custom.ClientSecret", true},
+		{"Target.ApiToken", true},
+		{"Target.TotpSeed", true},
+		{"Target.TotpCodes", true},
+		{"Target.PrivateKey", true},
+		{"Target.Custom.APIKEY", true},
+		{"Target.Custom.PIN", true}, // listed in sensitiveKeys
+		{"username", false},
+		{"Target.AccountDomainName", false},
+		{"Target.AssetNetworkAddress", false},
+		{"Target.Custom.tenantId", false},
+	} {
+		if got := isSensitiveKey(tc.key, []string{"target.custom.pin"}); got != tc.sensitive {
+			t.Errorf("isSensitiveKey(%q) = %v, want %v", tc.key, got, tc.sensitive)
+		}
+	}
+}
+
+func TestAddFromStdin(t *testing.T) {
+	s := &secretSet{values: map[string]bool{}}
+	s.addFromStdin(map[string]interface{}{
+		"username":          "jdoe",
+		"password":          testSecret,
+		"Target.TotpCodes":  `[{"Code":"123456","UnixTime":1700000000,"Period":30}]`,
+		"Target.Custom":     `{"tenantId":"contoso","apiKey":"k-98765"}`,
+		"Target.Custom.PIN": "7788",
+	}, []string{"Target.Custom.PIN"})
+	out := s.redact(`jdoe ` + testSecret + ` 123456 contoso k-98765 7788`)
+	assertNoSecret(t, out, testSecret, "123456", "k-98765", "7788")
+	if !strings.Contains(out, "jdoe") || !strings.Contains(out, "contoso") {
+		t.Errorf("values which are no secrets redacted: %s", out)
+	}
+}
+
+// assertNoSecret fails if any encoding of the secrets appears in text
+func assertNoSecret(t *testing.T, text string, secrets ...string) {
+	t.Helper()
+	for _, secret := range secrets {
+		encoded, _ := json.Marshal(secret)
+		for _, form := range []string{secret, string(encoded[1 : len(encoded)-1]), url.QueryEscape(secret), url.PathEscape(secret), url.User(secret).String()} {
+			if strings.Contains(text, form) {
+				t.Errorf("secret leaked as %q in %s", form, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Strategies which may prefix the selector of an action, like xpath://button[@type='submit'].
+// Selectors without a prefix are performed with chromedp_queryOption.
+var selectorStrategies = []string{"css", "id", "xpath", "text", "js"}
+
+// elementSelector is the selector of an action resolved to the chromedp query which finds its element
+type elementSelector struct {
+	query  string
+	option chromedp.QueryOption
+}
+
+// splitSelectorStrategy returns the strategy prefix and the rest of the selector, or "" and the selector itself if it has no known prefix
+func splitSelectorStrategy(selector string) (string, string) {
+	prefix, rest, found := strings.Cut(selector, ":")
+	if !found {
+		return "", selector
+	}
+	for _, strategy := range selectorStrategies {
+		if prefix == strategy {
+			return strategy, rest
+		}
+	}
+	return "", selector
+}
+
+// resolveSelector returns the chromedp query of a selector of loginActions. defaultOption is the query option of chromedp_queryOption.
+func resolveSelector(selector string, defaultOption chromedp.QueryOption) elementSelector {
+	strategy, rest := splitSelectorStrategy(selector)
+	switch strategy {
+	case "css":
+		return elementSelector{query: rest, option: chromedp.ByQuery}
+	case "id":
+		return elementSelector{query: rest, option: chromedp.ByID}
+	case "xpath":
+		return elementSelector{query: rest, option: chromedp.BySearch}
+	case "text":
+		// The innermost element whose whitespace normalized text is the given text, like the span within a button
+		text := xpathLiteral(strings.Join(strings.Fields(rest), " "))
+		return elementSelector{query: "//*[normalize-space(.)=" + text + "][not(*[normalize-space(.)=" + text + "])]", option: chromedp.BySearch}
+	case "js":
+		return elementSelector{query: rest, option: chromedp.ByJSPath}
+	default:
+		return elementSelector{query: selector, option: defaultOption}
+	}
+}
+
+// resolveSelectors resolves the selectors of the branches of a block
+func resolveSelectors(selectors []string, defaultOption chromedp.QueryOption) []elementSelector {
+	resolved := make([]elementSelector, len(selectors))
+	for i, selector := range selectors {
+		resolved[i] = resolveSelector(selector, defaultOption)
+	}
+	return resolved
+}
+
+// xpathLiteral quotes s as an XPath 1.0 string literal, which has no escape sequences
+func xpathLiteral(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	parts := strings.Split(s, "'")
+	for i, part := range parts {
+		parts[i] = "'" + part + "'"
+	}
+	return "concat(" + strings.Join(parts, `, "'", `) + ")"
+}
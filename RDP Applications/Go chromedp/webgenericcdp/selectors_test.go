@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+)
+
+func TestSplitSelectorStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		strategy string
+		rest     string
+	}{
+		{"#login", "", "#login"},
+		{"css:#login", "css", "#login"},
+		{"xpath://a[@href='x:y']", "xpath", "//a[@href='x:y']"},
+		{"text:Sign in: now", "text", "Sign in: now"},
+		// Only the known prefixes are strategies, the others are part of CSS selectors
+		{"input:not([disabled])", "", "input:not([disabled])"},
+		{"a:hover", "", "a:hover"},
+		{"CSS:#login", "", "CSS:#login"},
+	} {
+		strategy, rest := splitSelectorStrategy(tc.selector)
+		if strategy != tc.strategy || rest != tc.rest {
+			t.Errorf("%s: %q, %q, want %q, %q", tc.selector, strategy, rest, tc.strategy, tc.rest)
+		}
+	}
+}
+
+func TestResolveSelector(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		query    string
+		option   chromedp.QueryOption
+	}{
+		{"#login", "#login", chromedp.BySearch},
+		{"css:#login", "#login", chromedp.ByQuery},
+		{"id:login", "login", chromedp.ByID},
+		{"xpath://button[@type='submit']", "//button[@type='submit']", chromedp.BySearch},
+		{"js:document.forms[0].elements[1]", "document.forms[0].elements[1]", chromedp.ByJSPath},
+		{"text:  Sign \n in ", "//*[normalize-space(.)='Sign in'][not(*[normalize-space(.)='Sign in'])]", chromedp.BySearch},
+	} {
+		sel := resolveSelector(tc.selector, chromedp.BySearch)
+		// Query options are functions, compared by their address
+		option, want := reflect.ValueOf(sel.option).Pointer(), reflect.ValueOf(tc.option).Pointer()
+		if sel.query != tc.query || option != want {
+			t.Errorf("%s: query %q with option %#x, want %q with option %#x", tc.selector, sel.query, option, tc.query, want)
+		}
+	}
+	resolved := resolveSelectors([]string{"id:a", "#b"}, chromedp.ByQuery)
+	if len(resolved) != 2 || resolved[0].query != "a" || resolved[1].query != "#b" {
+		t.Errorf("resolveSelectors: %+v", resolved)
+	}
+}
+
+func TestXPathLiteral(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		literal string
+	}{
+		{"Sign in", "'Sign in'"},
+		{"Don't sign out", `"Don't sign out"`},
+		{`Say "it's"`, `concat('Say "it', "'", 's"')`},
+		{"", "''"},
+	} {
+		if got := xpathLiteral(tc.s); got != tc.literal {
+			t.Errorf("%s: %s, want %s", tc.s, got, tc.literal)
+		}
+	}
+}
+
+// Page of TestResolveSelectorBrowser
+const selectorsTestPage = `<form><input name="user"><button id="submit" type="submit"><span id="label">Say "it's"</span></button></form>
+<p>Say "it's" <b>later</b></p>`
+
+func TestResolveSelectorBrowser(t *testing.T) {
+	launch, _ := testBrowserLaunch(t)
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	if err := chromedp.Run(ctx, chromedp.Navigate("data:text/html,"+url.PathEscape(selectorsTestPage))); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		selector string
+		id       string // Id of the element found
+	}{
+		{"button[type=submit]", "submit"},
+		{"css:#submit", "submit"},
+		{"id:submit", "submit"},
+		{"xpath://button[@type='submit']", "submit"},
+		{"js:document.forms[0].elements[1]", "submit"},
+		// The innermost element with the text, not the button which contains it
+		{`text:Say "it's"`, "label"},
+	} {
+		sel := resolveSelector(tc.selector, chromedp.ByQuery)
+		var nodes []*cdp.Node
+		if err := chromedp.Run(ctx, chromedp.Nodes(sel.query, &nodes, sel.option)); err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		if len(nodes) != 1 || nodes[0].AttributeValue("id") != tc.id {
+			t.Errorf("%s: %d elements, want the element %s", tc.selector, len(nodes), tc.id)
+		}
+	}
+}
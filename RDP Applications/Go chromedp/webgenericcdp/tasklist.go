@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// taskPlan is the chromedp taskList built from the configuration, together with a human readable description
+// of every task. The descriptions never contain secrets, they are used by the validate subcommand.
+// Tasks of blocks and optional actions are performed by their parent task, their descriptions are nested below it.
+type taskPlan struct {
+	tasks []chromedp.Action
+	steps []string
+
+	secretSelectors []elementSelector // Elements secrets are entered into, masked in the failure captures
+	certificates    *certificateScope // Scope of the trusted certificates, nil without trustedCAs and trustedSPKIPins
+}
+
+func (p *taskPlan) add(action chromedp.Action, step string) {
+	p.tasks = append(p.tasks, action)
+	p.steps = append(p.steps, step)
+}
+
+// describe adds a description without a task, like the else keyword of a block
+func (p *taskPlan) describe(step string) {
+	p.steps = append(p.steps, step)
+}
+
+// nest adds the descriptions of sub, indented
+func (p *taskPlan) nest(sub *taskPlan) {
+	for _, step := range sub.steps {
+		p.steps = append(p.steps, "    "+step)
+	}
+	p.secretSelectors = append(p.secretSelectors, sub.secretSelectors...)
+}
+
+// buildTaskList builds the chromedp taskList from the configuration and the values received from Safeguard.
+// It does not stop at the first problem, the returned error joins every problem found.
+// TOTP codes are selected when they are entered, so the plan can be built from a sample payload with expired codes.
+func buildTaskList(config Config, launcherStdin map[string]interface{}, uuid string) (*taskPlan, error) {
+	plan := &taskPlan{}
+	var errs []error
+
+	// Replace the placeholders of the URL with the values received from Safeguard, encoded for their part of the URL
+	urlTemplate, terr := parseTemplate(config.url)
+	if terr != nil {
+		return plan, fmt.Errorf("url: %w", terr)
+	}
+	target, err := renderURL(urlTemplate, launcherStdin)
+	if err != nil {
+		return plan, fmt.Errorf("url: %w", err)
+	}
+	config.url = target.String()
+	slog.Debug("Safeguard values inserted", "url", target.Redacted(), "sessionid", uuid)
+
+	// Query option of the selectors without a strategy prefix
+	queryOption := chromedp.ByID
+	switch {
+	case config.chromedp_queryOption == "ByQuery":
+		queryOption = chromedp.ByQuery
+	case config.chromedp_queryOption == "BySearch":
+		queryOption = chromedp.BySearch
+	}
+
+	// Credentials are only entered on the origin of the url and the origins of idpDomains
+	guard, err := newOriginGuard(target, config.idpDomains, config.browser_insecure)
+	if err != nil {
+		return plan, fmt.Errorf("url: %w", err)
+	}
+	config.credentialOrigins = guard
+	guardDesc := "Enter credentials only on " + guard.String()
+	if config.browser_insecure {
+		guardDesc += " (certificates are not verified, browser_insecure=true)"
+	}
+
+	// The requests of every window, including the windows opened later, are checked by the filters
+	var filters []requestFilter
+	filtered := &taskPlan{}
+
+	// The browser is confined to the web application
+	nav, err := newNavigationFilter(config.navigationAllow, config.navigationDeny, guard)
+	if err != nil {
+		return plan, err
+	}
+	if nav != nil {
+		filters = append(filters, nav)
+		filtered.describe("Confine the navigations: " + nav.String())
+	}
+
+	// Certificates of the internal CA are only trusted on the allowed origins
+	trust, err := loadCertificateTrust(config.trustedCAs, config.trustedSPKIPins)
+	if err != nil {
+		return plan, fmt.Errorf("trustedCAs: %w", err)
+	}
+	if trust != nil {
+		plan.certificates = newCertificateScope(trust, guard)
+		filters = append(filters, plan.certificates)
+		filtered.describe("Trust the certificates of " + trust.String() + " only on " + guard.String())
+	}
+
+	if len(filters) > 0 {
+		confine := []chromedp.Action{confineNewWindows(filters, uuid)}
+		if config.basicAuthUsername == "false" {
+			// With basicAuthUsername the requests are paused together with the authentication challenges
+			confine = append(confine, interceptRequests(filters, uuid))
+		}
+		plan.add(chromedp.Tasks(confine), "Check the requests of every window:")
+		plan.nest(filtered)
+	}
+
+	if config.basicAuthUsername != "false" {
+		slog.Debug("Basic Authentication", "username", config.basicAuthUsername, "sessionid", uuid)
+		slog.Debug("Building chromedp taskList..", "sessionid", uuid)
+
+		// A username without braces names the Safeguard value, as in earlier versions
+		usernameTemplate := keyTemplate(config.basicAuthUsername)
+		if strings.ContainsAny(config.basicAuthUsername, "{}") {
+			if usernameTemplate, terr = parseTemplate(config.basicAuthUsername); terr != nil {
+				return plan, fmt.Errorf("basicAuthUsername: %w", terr)
+			}
+		}
+		basicAuthUsername, err := usernameTemplate.render(launcherStdin)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("basicAuthUsername: %w", err))
+		}
+		password, err := stdinValue(launcherStdin, "password")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("basicAuthUsername: %w", err))
+		}
+		// The browser's authentication challenges are answered through CDP, the credentials are never part of the URL
+		plan.add(guard.start(uuid), guardDesc)
+		plan.add(answerHTTPAuth(guard, filters, basicAuthUsername, password, uuid), "Answer HTTP authentication challenges of "+guard.String()+" as "+basicAuthUsername+" with password <hidden>")
+		plan.add(timedTasks("navigation to "+config.url, "", config.actionTimeout, []chromedp.Action{chromedp.Navigate(config.url)}), "Navigate to "+config.url)
+		slog.Debug("[taskList] Navigate to target", "url", config.url, "sessionid", uuid)
+		if err := addLoginResultCheck(plan, config, queryOption, uuid); err != nil {
+			errs = append(errs, err)
+		}
+		return plan, errors.Join(errs...)
+	}
+
+	// Building chromedp taskList from the states of loginFlow
+	if config.loginFlow != "" {
+		flow, err := loadLoginFlow(config.loginFlow)
+		if err != nil {
+			for _, ferr := range splitErrors(err) {
+				errs = append(errs, fmt.Errorf("loginFlow %s: %w", config.loginFlow, ferr))
+			}
+			return plan, errors.Join(errs...)
+		}
+		slog.Debug("Building chromedp taskList from loginFlow..", "path", config.loginFlow, "states", len(flow.order), "sessionid", uuid)
+		plan.add(guard.start(uuid), guardDesc)
+		plan.add(timedTasks("navigation to "+config.url, "", config.actionTimeout, []chromedp.Action{chromedp.Navigate(config.url)}), "Navigate to "+config.url)
+		slog.Debug("[taskList] Navigate to target", "url", config.url, "sessionid", uuid)
+		errs = append(errs, addLoginFlow(plan, config, queryOption, flow, launcherStdin, uuid)...)
+		if err := addLoginResultCheck(plan, config, queryOption, uuid); err != nil {
+			errs = append(errs, err)
+		}
+		return plan, errors.Join(errs...)
+	}
+
+	// Building chromedp taskList from loginActions
+	actions, err := parseLoginActions(config.loginActions)
+	if err != nil {
+		for _, perr := range splitErrors(err) {
+			errs = append(errs, fmt.Errorf("loginActions %w", perr))
+		}
+		return plan, errors.Join(errs...)
+	}
+	slog.Debug("Parsed "+strconv.Itoa(len(actions))+" actions", "sessionid", uuid)
+	slog.Debug("Building chromedp taskList from loginActions..", "sessionid", uuid)
+
+	// Build tasklist
+	plan.add(guard.start(uuid), guardDesc)
+	plan.add(timedTasks("navigation to "+config.url, "", config.actionTimeout, []chromedp.Action{chromedp.Navigate(config.url)}), "Navigate to "+config.url)
+	slog.Debug("[taskList] Navigate to target", "url", config.url, "sessionid", uuid)
+	errs = append(errs, addLoginActions(plan, config, queryOption, actions, launcherStdin, uuid)...)
+	if err := addLoginResultCheck(plan, config, queryOption, uuid); err != nil {
+		errs = append(errs, err)
+	}
+
+	return plan, errors.Join(errs...)
+}
+
+// addLoginActions appends the tasks of the loginActions entries to the plan and returns the problems found
+func addLoginActions(plan *taskPlan, config Config, queryOption chromedp.QueryOption, actions []loginAction, launcherStdin map[string]interface{}, uuid string) []error {
+	var errs []error
+	for _, action := range actions {
+		timeout := config.actionTimeout
+		if action.timeout > 0 {
+			timeout = action.timeout
+		}
+		if action.kind == actionSleep {
+			// A sleep is not limited by actionTimeout, only by loginTimeout
+			timeout = 0
+		}
+		timeoutDesc := ""
+		if timeout > 0 {
+			timeoutDesc = " (timeout " + timeout.String() + ")"
+		}
+
+		switch {
+		case action.kind == actionIf || action.kind == actionFirst:
+			errs = append(errs, addBlock(plan, config, queryOption, action, timeout, launcherStdin, uuid)...)
+		case isWaitAction(action.kind):
+			plan.add(timedTasks(action.String(), action.selector, timeout, []chromedp.Action{waitAction(action, queryOption)}), describeWaitAction(action)+timeoutDesc)
+			slog.Debug("[taskList] Wait", "kind", string(action.kind), "selector", action.selector, "sessionid", uuid)
+		case action.optional > 0:
+			// The action is performed only if its element appears within the configured period
+			sub := &taskPlan{}
+			if err := addLoginAction(sub, config, queryOption, action, launcherStdin, uuid); err != nil {
+				errs = append(errs, fmt.Errorf("loginActions %s: %w", action, err))
+				continue
+			}
+			step, selector, wait := action.String(), action.selector, action.optional
+			sel := resolveSelector(selector, queryOption)
+			task := timedTasks(step, selector, timeout, sub.tasks)
+			plan.add(chromedp.ActionFunc(func(ctx context.Context) error {
+				i, err := waitForFirstElement(ctx, []elementSelector{sel}, wait)
+				if err != nil {
+					return stepError(ctx, ctx, step, selector, 0, err)
+				}
+				if i < 0 {
+					slog.Debug("[taskList] Optional element did not appear, skipping action", "selector", selector, "wait", wait.String(), "sessionid", uuid)
+					return nil
+				}
+				return task.Do(ctx)
+			}), "If "+selector+" appears within "+wait.String()+timeoutDesc+":")
+			plan.nest(sub)
+			slog.Debug("[taskList] Optional action", "selector", selector, "wait", wait.String(), "sessionid", uuid)
+		default:
+			sub := &taskPlan{}
+			if err := addLoginAction(sub, config, queryOption, action, launcherStdin, uuid); err != nil {
+				errs = append(errs, fmt.Errorf("loginActions %s: %w", action, err))
+				continue
+			}
+			// The tasks of an action (waiting for the element and performing the action) share its timeout
+			plan.add(timedTasks(action.String(), action.selector, timeout, sub.tasks), sub.steps[0]+timeoutDesc)
+			for _, step := range sub.steps[1:] {
+				plan.describe(step)
+			}
+			plan.secretSelectors = append(plan.secretSelectors, sub.secretSelectors...)
+		}
+	}
+	return errs
+}
+
+// addBlock appends an if or first block to the plan. Its single task waits for the first element of the branches
+// to appear and performs the actions of that branch, or the else branch if none of them appeared in time.
+// The timeout applies to the waiting, the actions of the branches have their own.
+func addBlock(plan *taskPlan, config Config, queryOption chromedp.QueryOption, block loginAction, timeout time.Duration, launcherStdin map[string]interface{}, uuid string) []error {
+	var errs []error
+	selectors := make([]string, len(block.branches))
+	branches := make([]*taskPlan, len(block.branches))
+	for i, branch := range block.branches {
+		selectors[i] = branch.selector
+		branches[i] = &taskPlan{}
+		errs = append(errs, addLoginActions(branches[i], config, queryOption, branch.actions, launcherStdin, uuid)...)
+	}
+	resolved := resolveSelectors(selectors, queryOption)
+	otherwise := &taskPlan{}
+	errs = append(errs, addLoginActions(otherwise, config, queryOption, block.otherwise, launcherStdin, uuid)...)
+
+	// An if block is skipped if its element does not appear, a first block only if it is optional or has an else branch
+	skippable := block.kind == actionIf || block.optional > 0 || len(block.otherwise) > 0
+	wait := block.within
+
+	var desc string
+	switch {
+	case block.kind == actionIf:
+		desc = "If " + selectors[0] + " appears within " + wait.String()
+	case wait > 0:
+		desc = "Wait for the first element to appear within " + wait.String()
+	case timeout > 0:
+		// Without within, the first block waits until its timeout
+		desc = "Wait up to " + timeout.String() + " for the first element to appear"
+	default:
+		desc = "Wait for the first element to appear without time limit"
+	}
+	if timeout > 0 && (block.kind == actionIf || wait > 0) {
+		desc += " (timeout " + timeout.String() + ")"
+	}
+	plan.add(chromedp.ActionFunc(func(ctx context.Context) error {
+		tctx, cancel := withTimeout(ctx, timeout)
+		i, err := waitForFirstElement(tctx, resolved, wait)
+		cancel()
+		if err != nil {
+			return stepError(ctx, tctx, block.String(), strings.Join(selectors, ", "), timeout, err)
+		}
+		if i >= 0 {
+			slog.Debug("[taskList] Element appeared, performing its actions", "selector", selectors[i], "sessionid", uuid)
+			return chromedp.Tasks(branches[i].tasks).Do(ctx)
+		}
+		if !skippable {
+			return &timeoutError{step: block.String(), selector: strings.Join(selectors, ", "), timeout: wait}
+		}
+		slog.Debug("[taskList] None of the elements appeared", "selectors", strings.Join(selectors, ", "), "wait", wait.String(), "sessionid", uuid)
+		return chromedp.Tasks(otherwise.tasks).Do(ctx)
+	}), desc+":")
+	if block.kind == actionIf {
+		plan.nest(branches[0])
+	} else {
+		for i, branch := range branches {
+			plan.describe("  Case " + selectors[i] + ":")
+			plan.nest(branch)
+		}
+	}
+	if len(block.otherwise) > 0 {
+		plan.describe("  Else:")
+		plan.nest(otherwise)
+	} else if skippable {
+		plan.describe("  Otherwise skip")
+	}
+	slog.Debug("[taskList] Block", "kind", string(block.kind), "selectors", strings.Join(selectors, ", "), "wait", wait.String(), "sessionid", uuid)
+	return errs
+}
+
+// addLoginAction appends the tasks of a single loginActions entry to the plan
+func addLoginAction(plan *taskPlan, config Config, queryOption chromedp.QueryOption, action loginAction, launcherStdin map[string]interface{}, uuid string) error {
+	sel := resolveSelector(action.selector, queryOption)
+
+	// If browserInputDelay is configured let's pause till that get passed
+	if !(config.browserInputDelay == 0) {
+		plan.add(chromedp.Sleep(time.Millisecond*time.Duration(config.browserInputDelay)), "Sleep "+strconv.Itoa(config.browserInputDelay)+" ms")
+		slog.Debug("[taskList] Sleep", "sleep_ms", strconv.Itoa(config.browserInputDelay), "sessionid", uuid)
+	} else {
+		// Otherwise let's wait until the browser presents the element
+		plan.add(chromedp.WaitReady(sel.query, sel.option), "Wait until element is ready: "+action.selector)
+		slog.Debug("[taskList] Waiting element to be visible: "+action.selector, "sessionid", uuid)
+	}
+
+	switch action.kind {
+	case actionClick:
+		plan.add(chromedp.Click(sel.query, sel.option, chromedp.NodeVisible), "Click "+action.selector)
+		slog.Debug("[taskList] Click", "selector", action.selector, "sessionid", uuid)
+	case actionValue:
+		switch action.value.kind {
+		case valueSafeguard:
+			// Enter value built from the values received from Safeguard
+			val, err := action.value.template.render(launcherStdin)
+			if err != nil {
+				return err
+			}
+			if secrets.redact(val) != val {
+				// The value contains a secret, like {password}, it is entered like the secret actions
+				plan.secretSelectors = append(plan.secretSelectors, sel)
+				plan.add(config.credentialOrigins.sendKeys(sel, val, uuid), "Enter value into "+action.selector+": "+val)
+			} else {
+				plan.add(chromedp.SendKeys(sel.query, val, sel.option, chromedp.NodeVisible), "Enter value into "+action.selector+": "+val)
+			}
+			slog.Debug("[taskList] Enter value", "selector", action.selector, "value", val, "sessionid", uuid)
+		case valueStatic:
+			// Enter static string from configuration
+			plan.add(chromedp.SendKeys(sel.query, action.value.text, sel.option, chromedp.NodeVisible), "Enter value into "+action.selector+": "+action.value.text)
+			slog.Debug("[taskList] Enter value", "selector", action.selector, "value", action.value.text, "sessionid", uuid)
+		case valueKeyboard:
+			// Enter static keyboard keys from configuration
+			keys := keyStrokesString(action.value.keys)
+			plan.add(pressKeys(sel, action.value.keys), "Press keyboard keys in "+action.selector+": "+keys)
+			slog.Debug("[taskList] Enter keyboard key", "selector", action.selector, "key", keys, "sessionid", uuid)
+		}
+	case actionSecret:
+		secret, err := action.value.template.render(launcherStdin)
+		if err != nil {
+			return err
+		}
+		secrets.add(secret)
+		plan.secretSelectors = append(plan.secretSelectors, sel)
+		plan.add(config.credentialOrigins.sendKeys(sel, secret, uuid), "Enter secret into "+action.selector+": <hidden>")
+		slog.Debug("[taskList] Enter secret", "selector", action.selector, "value", "<hidden>", "sessionid", uuid)
+	case actionOTP:
+		t, err := action.value.template.render(launcherStdin)
+		if err != nil {
+			return err
+		}
+		plan.secretSelectors = append(plan.secretSelectors, sel)
+		if action.otp.mode != otpCodes {
+			return addGeneratedOTP(plan, config.credentialOrigins, sel, action, t, launcherStdin, uuid)
+		}
+		codes, err := parseTOTPCodes(t)
+		if err != nil {
+			return err
+		}
+		secrets.add(t)
+		for _, c := range codes {
+			secrets.add(c.code)
+		}
+		// The code is selected when its field appears, so that it is still valid when it is entered
+		plan.add(typeTOTP(config.credentialOrigins, sel, codes, action.minSecondsBeforeExpiry, uuid), "Enter TOTP code into "+action.selector+": <hidden> (valid for at least "+strconv.Itoa(action.minSecondsBeforeExpiry)+" seconds, waiting for the next code if needed)")
+		slog.Debug("[taskList] Enter TOTP code", "selector", action.selector, "codes", len(codes), "sessionid", uuid)
+	}
+	return nil
+}
+
+// addGeneratedOTP appends the task of an o action which generates the code locally from the seed received from Safeguard
+func addGeneratedOTP(plan *taskPlan, guard *originGuard, sel elementSelector, action loginAction, seed string, launcherStdin map[string]interface{}, uuid string) error {
+	secrets.add(seed)
+	key, err := decodeOTPSeed(seed)
+	if err != nil {
+		return err
+	}
+	settings := action.otp
+	if settings.mode == otpHOTP {
+		c, err := settings.counter.render(launcherStdin)
+		if err != nil {
+			return fmt.Errorf("counter: %w", err)
+		}
+		counter, err := strconv.ParseUint(strings.TrimSpace(c), 10, 64)
+		if err != nil {
+			return fmt.Errorf("counter: %q is not a non-negative number", c)
+		}
+		plan.add(typeGeneratedOTP(guard, sel, key, counter, settings, 0, uuid), "Enter HOTP code into "+action.selector+": <hidden> ("+strconv.Itoa(settings.digits)+" digits, "+settings.algorithm+", counter "+strconv.FormatUint(counter, 10)+")")
+		slog.Debug("[taskList] Enter HOTP code, the counter is not incremented", "selector", action.selector, "sessionid", uuid)
+		return nil
+	}
+	desc := fmt.Sprintf("Enter TOTP code into %s: <hidden> (%d digits, %s, period %ds, valid for at least %d seconds", action.selector, settings.digits, settings.algorithm, settings.period, action.minSecondsBeforeExpiry)
+	if settings.clockOffset != 0 {
+		desc += ", clock offset " + settings.clockOffset.String()
+	}
+	plan.add(typeGeneratedOTP(guard, sel, key, 0, settings, action.minSecondsBeforeExpiry, uuid), desc+")")
+	slog.Debug("[taskList] Enter TOTP code", "selector", action.selector, "sessionid", uuid)
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+// Templates of values, url and basicAuthUsername.
+//
+// Syntax:
+//
+//	template    = { literal | "{{" | "}}" | placeholder }
+//	placeholder = "{" key { ":" transform } [ "|" default ] "}"
+//
+// {{ and }} stand for literal { and }, also in templates without placeholders. A v value which is not a valid template, like Pa{ss, is entered as written.
+// The values received from Safeguard are inserted as they are, they are never parsed as templates. The key names a value received from Safeguard via STDIN, like
+// Target.AccountDomainName, or a path into it, like Target.TotpCodes[0].Code (see values.go). The default is used if the value is missing or empty. The transforms are applied
+// in order to the value (or to the default), e.g. {Target.AccountDomainName:netbios|corp}\{username}.
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transforms of placeholders, like {Target.AccountDomainName:netbios}
+var templateTransforms = map[string]func(string) string{
+	"upper":        strings.ToUpper,
+	"lower":        strings.ToLower,
+	"netbios":      netbiosName,
+	"strip-domain": stripDomain,
+	"urlencode":    url.QueryEscape,
+}
+
+// templatePart is a literal or a placeholder of a template
+type templatePart struct {
+	literal    string
+	key        string // Empty for literals
+	transforms []string
+	def        string
+	hasDefault bool
+	column     int // 1-based column of the placeholder within the template
+}
+
+// valueTemplate is a parsed template
+type valueTemplate struct {
+	parts  []templatePart
+	source string
+}
+
+// templateError is a syntax error in a template. Column is 1-based, counted in characters within the template.
+type templateError struct {
+	column int
+	msg    string
+}
+
+func (e *templateError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.column, e.msg)
+}
+
+// parseTemplate parses src according to the syntax above
+func parseTemplate(src string) (*valueTemplate, *templateError) {
+	t := &valueTemplate{source: src}
+	var literal strings.Builder
+	column := func(i int) int { return utf8.RuneCountInString(src[:i]) + 1 }
+	for i := 0; i < len(src); {
+		switch {
+		case strings.HasPrefix(src[i:], "{{"):
+			literal.WriteByte('{')
+			i += 2
+		case strings.HasPrefix(src[i:], "}}"):
+			literal.WriteByte('}')
+			i += 2
+		case src[i] == '}':
+			return t, &templateError{column: column(i), msg: "unexpected }, use }} for a literal }"}
+		case src[i] == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return t, &templateError{column: column(i), msg: "placeholder is not closed with }, use {{ for a literal {"}
+			}
+			part, err := parsePlaceholder(src[i+1:i+end], column(i))
+			if err != nil {
+				return t, err
+			}
+			if literal.Len() > 0 {
+				t.parts = append(t.parts, templatePart{literal: literal.String()})
+				literal.Reset()
+			}
+			t.parts = append(t.parts, part)
+			i += end + 1
+		default:
+			literal.WriteByte(src[i])
+			i++
+		}
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, templatePart{literal: literal.String()})
+	}
+	return t, nil
+}
+
+// parsePlaceholder parses the text between the braces of a placeholder starting at column
+func parsePlaceholder(text string, column int) (templatePart, *templateError) {
+	part := templatePart{column: column}
+	spec, def, hasDefault := strings.Cut(text, "|")
+	part.def, part.hasDefault = def, hasDefault
+	fields := strings.Split(spec, ":")
+	part.key = strings.TrimSpace(fields[0])
+	if part.key == "" {
+		return part, &templateError{column: column, msg: "missing Safeguard value name in placeholder {" + text + "}"}
+	}
+	if strings.Contains(part.key, "{") {
+		return part, &templateError{column: column, msg: "placeholder is not closed with }, use {{ for a literal {"}
+	}
+	if _, err := parseValuePath(part.key); err != nil {
+		return part, &templateError{column: column, msg: err.Error()}
+	}
+	for _, name := range fields[1:] {
+		name = strings.TrimSpace(name)
+		if _, ok := templateTransforms[name]; !ok {
+			names := make([]string, 0, len(templateTransforms))
+			for n := range templateTransforms {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			return part, &templateError{column: column, msg: fmt.Sprintf("unknown transform %q in placeholder {%s}, supported transforms: %s", name, text, strings.Join(names, ", "))}
+		}
+		part.transforms = append(part.transforms, name)
+	}
+	return part, nil
+}
+
+// isValueTemplate reports whether src is a valid template, with or without placeholders
+func isValueTemplate(src string) bool {
+	_, err := parseTemplate(src)
+	return err == nil
+}
+
+// keyTemplate returns the template of a single placeholder, used where the configuration names a Safeguard value without braces
+func keyTemplate(key string) *valueTemplate {
+	return &valueTemplate{parts: []templatePart{{key: key, column: 1}}, source: key}
+}
+
+// render returns the template with its placeholders replaced by the values received from Safeguard.
+// The returned error lists every missing value.
+func (t *valueTemplate) render(launcherStdin map[string]interface{}) (string, error) {
+	var b strings.Builder
+	var missing []*lookupError
+	for _, part := range t.parts {
+		if part.key == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		val, err := part.resolve(launcherStdin)
+		if err != nil {
+			missing = append(missing, err)
+			continue
+		}
+		b.WriteString(val)
+	}
+	if len(missing) > 0 {
+		return "", missingValuesError(missing)
+	}
+	return b.String(), nil
+}
+
+// resolve returns the value of a placeholder with its default and transforms applied, or why it was not found
+func (part templatePart) resolve(launcherStdin map[string]interface{}) (string, *lookupError) {
+	var val string
+	v, err := lookupValue(launcherStdin, part.key)
+	if err == nil {
+		val = formatValue(v)
+	}
+	switch {
+	case (err != nil || val == "") && part.hasDefault:
+		val = part.def
+	case err != nil:
+		return "", err
+	}
+	for _, name := range part.transforms {
+		val = templateTransforms[name](val)
+	}
+	return val, nil
+}
+
+// netbiosName returns the NetBIOS domain name guessed from a DNS domain name: its first label in upper case, at most 15 characters
+func netbiosName(fqdn string) string {
+	name, _, _ := strings.Cut(fqdn, ".")
+	name = strings.ToUpper(name)
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	return name
+}
+
+// stripDomain returns the user name of a down-level logon name (DOMAIN\user) or a UPN (user@domain)
+func stripDomain(name string) string {
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
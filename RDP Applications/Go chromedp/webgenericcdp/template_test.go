@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStaticValueWithBraces(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		kind valueKind
+	}{
+		{"v::#field::Pa{ss", valueStatic},
+		{"v::#field::Pa}ss", valueStatic},
+		{"v::#field::{}", valueStatic},
+		{"v::#field::}{", valueStatic},
+		// The escapes are applied with or without placeholders
+		{"v::#field::a{{b}}c", valueSafeguard},
+		{"v::#field::{username}", valueSafeguard},
+		{"v::#field::{{{username}}}", valueSafeguard},
+	} {
+		actions, err := parseLoginActions(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		if got := actions[0].value.kind; got != tc.kind {
+			t.Errorf("%s: kind %d, want %d", tc.src, got, tc.kind)
+		}
+	}
+}
+
+func TestRenderNeverExpandsValues(t *testing.T) {
+	stdin := map[string]interface{}{"password": "p{username}}{{x", "username": "jdoe"}
+	tmpl, terr := parseTemplate("{username}:{password}")
+	if terr != nil {
+		t.Fatal(terr)
+	}
+	got, err := tmpl.render(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "jdoe:p{username}}{{x"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"a}b", "column 2: unexpected }, use }} for a literal }"},
+		{"ab{user", "column 3: placeholder is not closed with }, use {{ for a literal {"},
+		{"ü{}", "column 2: missing Safeguard value name in placeholder {}"},
+		{"{ :upper}", "column 1: missing Safeguard value name in placeholder { :upper}"},
+		{"x{a{b}", "column 2: placeholder is not closed with }, use {{ for a literal {"},
+		{"{user:title}", `column 1: unknown transform "title" in placeholder {user:title}, supported transforms: lower, netbios, strip-domain, upper, urlencode`},
+		{"{{x{Target.Custom[}", `column 4: invalid index [ in value path "Target.Custom["`},
+		{"{Target.Codes[x]}", `column 1: invalid index [x] in value path "Target.Codes[x]"`},
+	} {
+		_, err := parseTemplate(tc.src)
+		if err == nil {
+			t.Errorf("%s: no error", tc.src)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%s: error %q, want %q", tc.src, err, tc.err)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	stdin := map[string]interface{}{
+		"username":                 "jdoe",
+		"Target.AccountDomainName": "contoso.example.com",
+		"Target.Empty":             "",
+		"Target.Port":              json.Number("8443"),
+		"Target.TotpCodes":         `[{"Code":"123456"}]`,
+		"Target.Custom":            map[string]interface{}{"tenantId": "T1", "user": `CONTOSO\jdoe`},
+	}
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"{username}", "jdoe"},
+		{`{Target.AccountDomainName:netbios}\{username}`, `CONTOSO\jdoe`},
+		{"{username:upper}@{Target.AccountDomainName:upper:lower}", "JDOE@contoso.example.com"},
+		{"{Target.Custom.user:strip-domain}", "jdoe"},
+		{"{Target.Custom.tenantId:lower}", "t1"},
+		{"{Target.Empty|fallback}", "fallback"},
+		{"{Target.Missing|corp:upper}", "corp:upper"},
+		{"{Target.Missing:upper|corp}", "CORP"},
+		{"{Target.Missing|}x", "x"},
+		{"{Target.TotpCodes[0].Code}", "123456"},
+		{"{Target.Port}", "8443"},
+		{"{{{username}}}", "{jdoe}"},
+		{"a{{b}}c", "a{b}c"},
+		{"{username}{{x}}", "jdoe{x}"},
+		{"q={username:urlencode}&d={Target.Custom.user:urlencode}", `q=jdoe&d=CONTOSO%5Cjdoe`},
+	} {
+		tmpl, terr := parseTemplate(tc.src)
+		if terr != nil {
+			t.Errorf("%s: %v", tc.src, terr)
+			continue
+		}
+		got, err := tmpl.render(stdin)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+
+	tmpl, _ := parseTemplate("{password}:{Target.Empty}:{Target.Custom.missing}")
+	_, err := tmpl.render(stdin)
+	if err == nil {
+		t.Fatal("missing values rendered")
+	}
+	for _, want := range []string{"password", "Target.Custom.missing", "received keys: Target.AccountDomainName"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if missing, _, _ := strings.Cut(err.Error(), ";"); strings.Contains(missing, "Target.Empty") {
+		t.Errorf("empty value reported as missing: %q", err)
+	}
+}
+
+func TestTemplateTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fn   func(string) string
+		in   string
+		want string
+	}{
+		{"netbios", netbiosName, "contoso.example.com", "CONTOSO"},
+		{"netbios long", netbiosName, "averyverylongdomainname.com", "AVERYVERYLONGDO"},
+		{"netbios single label", netbiosName, "corp", "CORP"},
+		{"strip-domain down-level", stripDomain, `CORP\jdoe`, "jdoe"},
+		{"strip-domain upn", stripDomain, "jdoe@corp.example.com", "jdoe"},
+		{"strip-domain plain", stripDomain, "jdoe", "jdoe"},
+	} {
+		if got := tc.fn(tc.in); got != tc.want {
+			t.Errorf("%s(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
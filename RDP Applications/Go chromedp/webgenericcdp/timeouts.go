@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// timeoutError reports the step which was being performed when a timeout expired
+type timeoutError struct {
+	step     string        // The action being performed, as written in the configuration
+	selector string        // The selector(s) being waited for, if any
+	timeout  time.Duration // The timeout of the step, 0 if the loginTimeout expired
+}
+
+func (e *timeoutError) Error() string {
+	what := e.step
+	if e.selector != "" {
+		what += " waiting for " + e.selector
+	}
+	if e.timeout == 0 {
+		return "loginTimeout expired while performing " + what
+	}
+	return fmt.Sprintf("timed out after %s while performing %s", e.timeout, what)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// withTimeout returns a context for a step limited to timeout, or ctx itself if timeout is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stepError turns err into a timeoutError naming the step if the step's context (tctx) or the login context (ctx) expired.
+// Errors already naming a nested step are returned as they are.
+func stepError(ctx context.Context, tctx context.Context, step string, selector string, timeout time.Duration, err error) error {
+	if err == nil || tctx.Err() == nil {
+		return err
+	}
+	if _, ok := err.(*timeoutError); ok {
+		return err
+	}
+	if ctx.Err() != nil {
+		return &timeoutError{step: step, selector: selector}
+	}
+	return &timeoutError{step: step, selector: selector, timeout: timeout}
+}
+
+// timedTasks returns a single task which performs tasks within timeout, reporting a timeoutError naming the step
+func timedTasks(step string, selector string, timeout time.Duration, tasks []chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		tctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		return stepError(ctx, tctx, step, selector, timeout, chromedp.Tasks(tasks).Do(tctx))
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// totpCode is a code of the Target.TotpCodes JSON received from Safeguard, valid from unixTime for period seconds
+type totpCode struct {
+	code     string
+	unixTime int64
+	period   int64
+}
+
+func (c totpCode) expiry() time.Time {
+	return time.Unix(c.unixTime+c.period, 0)
+}
+
+var errNoValidTOTP = errors.New("have not found valid TOTP code")
+
+// parseTOTPCodes parses the TOTP JSON received from Safeguard, like [{"Code":"123456","UnixTime":1700000000,"Period":30},...],
+// and returns the codes ordered by the start of their validity
+func parseTOTPCodes(t string) ([]totpCode, error) {
+	var raw []struct {
+		Code     *string  `json:"Code"`
+		UnixTime *float64 `json:"UnixTime"`
+		Period   *float64 `json:"Period"`
+	}
+	if err := json.Unmarshal([]byte(t), &raw); err != nil {
+		return nil, fmt.Errorf("error occured while parsing TOTP JSON: %w", err)
+	}
+	codes := make([]totpCode, 0, len(raw))
+	for i, r := range raw {
+		switch {
+		case r.Code == nil:
+			return nil, fmt.Errorf("TOTP %d: Code is missing or not a string", i+1)
+		case r.UnixTime == nil:
+			return nil, fmt.Errorf("TOTP %d: UnixTime is missing", i+1)
+		case r.Period == nil || *r.Period <= 0:
+			return nil, fmt.Errorf("TOTP %d: Period is missing or not positive", i+1)
+		}
+		codes = append(codes, totpCode{code: *r.Code, unixTime: int64(*r.UnixTime), period: int64(*r.Period)})
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("TOTP JSON contains no codes")
+	}
+	sort.SliceStable(codes, func(i, j int) bool { return codes[i].unixTime < codes[j].unixTime })
+	return codes, nil
+}
+
+// selectTOTP returns the first code which is valid for at least minSecondsBeforeExpiry seconds at now, or at the start
+// of its validity if that is later. The returned duration is the time to wait until the code becomes valid.
+func selectTOTP(codes []totpCode, now time.Time, minSecondsBeforeExpiry int, uuid string) (totpCode, time.Duration, error) {
+	slog.Debug("[TOTP_Lookup] Required seconds before TOTP expiry: "+strconv.Itoa(minSecondsBeforeExpiry), "sessionid", uuid)
+	minValidity := time.Duration(minSecondsBeforeExpiry) * time.Second
+	for i, c := range codes {
+		start := time.Unix(c.unixTime, 0)
+		wait := time.Duration(0)
+		if start.After(now) {
+			wait = start.Sub(now)
+		}
+		validity := c.expiry().Sub(now.Add(wait))
+		slog.Debug("[TOTP_Lookup] Examining TOTP "+strconv.Itoa(i+1), "UnixTime", c.unixTime, "Period", c.period, "valid_for", validity.String(), "sessionid", uuid)
+		if validity >= minValidity {
+			return c, wait, nil
+		}
+	}
+	last := codes[len(codes)-1]
+	return totpCode{}, 0, fmt.Errorf("%w: none of the %d codes is valid for at least %d seconds, the last one expires at %s", errNoValidTOTP, len(codes), minSecondsBeforeExpiry, last.expiry().UTC().Format(time.RFC3339))
+}
+
+// typeTOTP selects the TOTP code when the action is performed, right before it is entered
+func typeTOTP(guard *originGuard, sel elementSelector, codes []totpCode, minSecondsBeforeExpiry int, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		return enterTOTP(ctx, guard, sel, codes, time.Now(), minSecondsBeforeExpiry, uuid)
+	})
+}
+
+// enterTOTP enters the first of the codes which is valid for at least minSecondsBeforeExpiry seconds,
+// waiting for the next code if the current one expires sooner. The origin of the page is verified by guard after the waiting.
+func enterTOTP(ctx context.Context, guard *originGuard, sel elementSelector, codes []totpCode, now time.Time, minSecondsBeforeExpiry int, uuid string) error {
+	c, wait, err := selectTOTP(codes, now, minSecondsBeforeExpiry, uuid)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		slog.Info("[TOTP_Lookup] Waiting "+wait.Round(time.Second).String()+" for the next TOTP code to become valid", "sessionid", uuid)
+		if err := chromedp.Sleep(wait).Do(ctx); err != nil {
+			return err
+		}
+	}
+	secrets.add(c.code)
+	slog.Debug("[TOTP_Lookup] Found valid TOTP code, expiring at "+c.expiry().UTC().Format(time.RFC3339), "TOTP_code", c.code, "sessionid", uuid)
+	return guard.sendKeys(sel, c.code, uuid).Do(ctx)
+}
+
+// otpMode tells where the codes of an o action come from
+type otpMode int
+
+const (
+	otpCodes otpMode = iota // The Target.TotpCodes JSON received from Safeguard
+	otpTOTP                 // Generated locally from a base32 seed (RFC 6238)
+	otpHOTP                 // Generated locally from a base32 seed and a counter (RFC 4226)
+)
+
+// otpSettings are the options of an o action generating the codes locally, like o(totp,digits=8,algorithm=SHA256)
+type otpSettings struct {
+	mode        otpMode
+	digits      int
+	period      int64          // Seconds, TOTP only
+	algorithm   string         // SHA1, SHA256 or SHA512
+	clockOffset time.Duration  // Added to the local clock before the time step is computed, TOTP only, see typeGeneratedOTP
+	counter     *valueTemplate // HOTP only, managed outside of webgenericcdp, see typeGeneratedOTP
+}
+
+// Hash functions of the algorithm option
+var otpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// parseOTPOption parses an option of the o action which generates the codes locally
+func parseOTPOption(settings *otpSettings, opt actionOption) *actionParseError {
+	optErr := func(format string, args ...any) *actionParseError {
+		return &actionParseError{column: opt.column, msg: opt.name + ": " + fmt.Sprintf(format, args...)}
+	}
+	flag := opt.name == "totp" || opt.name == "hotp"
+	if flag && opt.set {
+		return optErr("does not take a value")
+	}
+	if !flag && !opt.set {
+		return optErr("missing value")
+	}
+	switch opt.name {
+	case "totp", "hotp":
+		if settings.mode != otpCodes {
+			return optErr("totp and hotp can not be combined")
+		}
+		settings.mode = otpTOTP
+		if opt.name == "hotp" {
+			settings.mode = otpHOTP
+		}
+	case "digits":
+		digits, err := strconv.Atoi(opt.value)
+		if err != nil || digits < 6 || digits > 10 {
+			return optErr("%q must be a number from 6 to 10", opt.value)
+		}
+		settings.digits = digits
+	case "period":
+		period, err := strconv.Atoi(opt.value)
+		if err != nil {
+			d, derr := time.ParseDuration(opt.value)
+			period, err = int(d/time.Second), derr
+		}
+		if err != nil || period <= 0 {
+			return optErr("%q must be a positive number of seconds or a duration like 30s", opt.value)
+		}
+		settings.period = int64(period)
+	case "algorithm":
+		algorithm := strings.ToUpper(strings.ReplaceAll(opt.value, "-", ""))
+		if _, ok := otpAlgorithms[algorithm]; !ok {
+			return optErr("%q is not supported, accepted values: SHA1|SHA256|SHA512", opt.value)
+		}
+		settings.algorithm = algorithm
+	case "clock-offset":
+		offset, err := time.ParseDuration(opt.value)
+		if err != nil {
+			return optErr("%q must be a duration like 5s or -5s", opt.value)
+		}
+		settings.clockOffset = offset
+	case "counter":
+		t, err := parseTemplate(opt.value)
+		if err != nil {
+			return &actionParseError{column: opt.column, msg: "counter: " + err.msg}
+		}
+		settings.counter = t
+	}
+	return nil
+}
+
+// checkOTPOptions checks the combination of the options of an o action and sets the defaults
+func checkOTPOptions(settings *otpSettings, options []actionOption, hasMinSeconds bool) *actionParseError {
+	for _, opt := range options {
+		switch {
+		case settings.mode == otpCodes && slices.Contains([]string{"digits", "period", "algorithm", "clock-offset", "counter"}, opt.name):
+			return &actionParseError{column: opt.column, msg: opt.name + " requires the totp or hotp option"}
+		case settings.mode == otpHOTP && (opt.name == "period" || opt.name == "clock-offset"):
+			return &actionParseError{column: opt.column, msg: opt.name + " is not supported with hotp"}
+		case settings.mode == otpTOTP && opt.name == "counter":
+			return &actionParseError{column: opt.column, msg: "counter is only supported with hotp"}
+		case settings.mode == otpHOTP && hasMinSeconds && opt.name == "hotp":
+			return &actionParseError{column: opt.column, msg: "HOTP codes do not expire, remove min-seconds-before-expiry"}
+		}
+	}
+	if settings.mode == otpCodes {
+		return nil
+	}
+	if settings.mode == otpHOTP && settings.counter == nil {
+		for _, opt := range options {
+			if opt.name == "hotp" {
+				return &actionParseError{column: opt.column, msg: "hotp requires counter={<Safeguard value of the counter>}"}
+			}
+		}
+	}
+	if settings.digits == 0 {
+		settings.digits = 6
+	}
+	if settings.period == 0 {
+		settings.period = 30
+	}
+	if settings.algorithm == "" {
+		settings.algorithm = "SHA1"
+	}
+	return nil
+}
+
+// decodeOTPSeed decodes a base32 seed, ignoring spaces, dashes, padding and case as authenticator apps do
+func decodeOTPSeed(seed string) ([]byte, error) {
+	seed = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(seed))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("OTP seed is not a valid base32 string")
+	}
+	return key, nil
+}
+
+// generateOTP returns the HOTP value of the counter (RFC 4226), which is the TOTP value of a time step (RFC 6238)
+func generateOTP(key []byte, counter uint64, settings otpSettings) string {
+	mac := hmac.New(otpAlgorithms[settings.algorithm], key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	modulo := uint64(1)
+	for i := 0; i < settings.digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", settings.digits, value%modulo)
+}
+
+// typeGeneratedOTP generates the code from the seed when the action is performed, right before it is entered.
+//
+// TOTP codes are generated for the time step of the local clock plus clockOffset and for the next one, the same expiry
+// rules apply as to the codes received from Safeguard. clockOffset is a fixed correction of a clock known to be off,
+// not a tolerance: the codes of earlier or later time steps are never tried.
+//
+// The HOTP code is the one of counter, which webgenericcdp neither increments nor stores. The server increments its
+// counter with every code it accepts, so the counter received from Safeguard must be managed outside of webgenericcdp,
+// like by a Safeguard custom platform script incrementing it after each session.
+func typeGeneratedOTP(guard *originGuard, sel elementSelector, key []byte, counter uint64, settings otpSettings, minSecondsBeforeExpiry int, uuid string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if settings.mode == otpHOTP {
+			code := generateOTP(key, counter, settings)
+			secrets.add(code)
+			slog.Debug("[OTP_Generate] Generated HOTP code", "counter", counter, "sessionid", uuid)
+			return guard.sendKeys(sel, code, uuid).Do(ctx)
+		}
+		now := time.Now().Add(settings.clockOffset)
+		step := now.Unix() / settings.period
+		var codes []totpCode
+		for s := step; s <= step+1; s++ {
+			codes = append(codes, totpCode{code: generateOTP(key, uint64(s), settings), unixTime: s * settings.period, period: settings.period})
+		}
+		return enterTOTP(ctx, guard, sel, codes, now, minSecondsBeforeExpiry, uuid)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateHOTP(t *testing.T) {
+	// RFC 4226, appendix D
+	key := []byte("12345678901234567890")
+	settings := otpSettings{mode: otpHOTP, digits: 6, algorithm: "SHA1"}
+	for counter, want := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		if got := generateOTP(key, uint64(counter), settings); got != want {
+			t.Errorf("counter %d: %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238, appendix B: 8 digits, period 30 seconds, a seed of the length of the hash for each algorithm
+	keys := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	for _, tc := range []struct {
+		unixTime int64
+		codes    map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	} {
+		for algorithm, want := range tc.codes {
+			settings := otpSettings{mode: otpTOTP, digits: 8, period: 30, algorithm: algorithm}
+			if got := generateOTP(keys[algorithm], uint64(tc.unixTime/settings.period), settings); got != want {
+				t.Errorf("%d %s: %s, want %s", tc.unixTime, algorithm, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeOTPSeed(t *testing.T) {
+	seed := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, s := range []string{seed, strings.ToLower(seed), strings.TrimRight(seed, "="), "GEZD GNBV-GY3T QOJQ GEZD GNBV GY3T QOJQ"} {
+		key, err := decodeOTPSeed(s)
+		if err != nil || string(key) != "12345678901234567890" {
+			t.Errorf("%s: %q, %v", s, key, err)
+		}
+	}
+	for _, s := range []string{"", "====", "GEZD1"} {
+		if _, err := decodeOTPSeed(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestParseTOTPCodes(t *testing.T) {
+	codes, err := parseTOTPCodes(`[{"Code":"222222","UnixTime":1700000030,"Period":30},{"Code":"111111","UnixTime":1700000000,"Period":30}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0].code != "111111" || codes[1].code != "222222" || codes[0].expiry() != time.Unix(1700000030, 0) {
+		t.Errorf("codes %+v", codes)
+	}
+	for _, tc := range []struct {
+		json string
+		err  string
+	}{
+		{`[]`, "contains no codes"},
+		{`{"Code":"1"}`, "error occured while parsing TOTP JSON"},
+		{`[{"Code":123456,"UnixTime":1,"Period":30}]`, "error occured while parsing TOTP JSON"},
+		{`[{"UnixTime":1,"Period":30}]`, "TOTP 1: Code is missing"},
+		{`[{"Code":"1","Period":30}]`, "TOTP 1: UnixTime is missing"},
+		{`[{"Code":"1","UnixTime":1,"Period":0}]`, "TOTP 1: Period is missing or not positive"},
+	} {
+		if _, err := parseTOTPCodes(tc.json); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want %q", tc.json, err, tc.err)
+		}
+	}
+}
+
+func TestSelectTOTP(t *testing.T) {
+	codes := []totpCode{{code: "111111", unixTime: 1000, period: 30}, {code: "222222", unixTime: 1030, period: 30}}
+	for _, tc := range []struct {
+		now        int64
+		minSeconds int
+		code       string
+		wait       time.Duration
+	}{
+		{now: 1000, minSeconds: 0, code: "111111"},
+		{now: 1025, minSeconds: 5, code: "111111"},
+		// The current code expires too soon, the next one is waited for
+		{now: 1026, minSeconds: 5, code: "222222", wait: 4 * time.Second},
+		{now: 1040, minSeconds: 5, code: "222222"},
+	} {
+		c, wait, err := selectTOTP(codes, time.Unix(tc.now, 0), tc.minSeconds, "test")
+		if err != nil || c.code != tc.code || wait != tc.wait {
+			t.Errorf("%d: %s, wait %s, %v", tc.now, c.code, wait, err)
+		}
+	}
+	if _, _, err := selectTOTP(codes, time.Unix(1056, 0), 5, "test"); !errors.Is(err, errNoValidTOTP) {
+		t.Errorf("expired codes: %v", err)
+	}
+}
+
+func TestParseOTPOptions(t *testing.T) {
+	actions, err := parseLoginActions("o(totp,digits=8,period=1m,algorithm=sha-256,clock-offset=-5s)::#otp::{seed}||o(hotp,counter={counter})::#otp::{seed}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, hotp := actions[0].otp, actions[1].otp
+	if totp.mode != otpTOTP || totp.digits != 8 || totp.period != 60 || totp.algorithm != "SHA256" || totp.clockOffset != -5*time.Second {
+		t.Errorf("totp: %+v", totp)
+	}
+	if hotp.mode != otpHOTP || hotp.digits != 6 || hotp.algorithm != "SHA1" || hotp.counter == nil {
+		t.Errorf("hotp: %+v", hotp)
+	}
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"o(hotp)::#otp::{seed}", "column 3: hotp requires counter={<Safeguard value of the counter>}"},
+		{"o(hotp,counter={c},clock-offset=5s)::#otp::{seed}", "column 20: clock-offset is not supported with hotp"},
+		{"o(clock-offset=5s)::#otp::codes", "column 3: clock-offset requires the totp or hotp option"},
+		{"o(totp,clock-offset=5)::#otp::{seed}", `column 8: clock-offset: "5" must be a duration like 5s or -5s`},
+		{"o(totp,counter={c})::#otp::{seed}", "column 8: counter is only supported with hotp"},
+		{"o(totp,hotp)::#otp::{seed}", "column 8: hotp: totp and hotp can not be combined"},
+		{"o(hotp,counter={c})::#otp::{seed}::5", "column 3: HOTP codes do not expire, remove min-seconds-before-expiry"},
+		{"o(totp,digits=5)::#otp::{seed}", `column 8: digits: "5" must be a number from 6 to 10`},
+		{"o(totp,algorithm=md5)::#otp::{seed}", `column 8: algorithm: "md5" is not supported, accepted values: SHA1|SHA256|SHA512`},
+	} {
+		_, err := parseLoginActions(tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want %q", tc.src, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// urlComponent is the part of a URL a placeholder of the url setting is inserted into
+type urlComponent int
+
+const (
+	urlUserinfo urlComponent = iota
+	urlHost
+	urlPort
+	urlPath
+	urlQuery
+	urlFragment
+)
+
+// Stands for a placeholder while the template of the url setting is analyzed, a private use character
+const urlPlaceholderMark = '\uE000'
+
+var urlSchemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// renderURL renders the template of the url setting. Each value is encoded for the part of the URL it is inserted into:
+// user info and path segments are escaped, query and fragment values are URL-encoded, IPv6 addresses are bracketed
+// and ports are checked to be numeric. The urlencode transform is ignored, the values are already encoded. An empty port, like {Target.Port|} without a value, is omitted.
+// If the URL has no scheme, https is used.
+func renderURL(t *valueTemplate, launcherStdin map[string]interface{}) (*url.URL, error) {
+	// Skeleton of the URL with a mark for each placeholder, it tells which component a placeholder belongs to
+	var skeleton strings.Builder
+	var placeholders []templatePart
+	for _, part := range t.parts {
+		if part.key == "" {
+			skeleton.WriteString(part.literal)
+			continue
+		}
+		skeleton.WriteRune(urlPlaceholderMark)
+		placeholders = append(placeholders, part)
+	}
+	s := skeleton.String()
+	if !urlSchemePattern.MatchString(s) {
+		s = "https://" + s
+	}
+	authorityStart := strings.Index(s, "://") + 3
+	authorityEnd := len(s)
+	if i := strings.IndexAny(s[authorityStart:], "/?#"); i >= 0 {
+		authorityEnd = authorityStart + i
+	}
+	hostStart := authorityStart
+	if i := strings.LastIndex(s[authorityStart:authorityEnd], "@"); i >= 0 {
+		hostStart = authorityStart + i + 1
+	}
+
+	var b strings.Builder
+	var missing []*lookupError
+	var errs []string
+	n := 0
+	for i, c := range s {
+		if c != urlPlaceholderMark {
+			b.WriteRune(c)
+			continue
+		}
+		part := placeholders[n]
+		n++
+		// The value is encoded below for its component, urlencode would encode it twice
+		part.transforms = slices.DeleteFunc(slices.Clone(part.transforms), func(name string) bool { return name == "urlencode" })
+		val, lerr := part.resolve(launcherStdin)
+		if lerr != nil {
+			missing = append(missing, lerr)
+			continue
+		}
+		encoded, err := encodeURLValue(val, urlComponentAt(s, i, authorityEnd, hostStart), i > 0 && s[i-1] == '[')
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("value of {%s}: %s", part.key, err))
+			continue
+		}
+		b.WriteString(encoded)
+	}
+	if len(missing) > 0 {
+		return nil, missingValuesError(missing)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	u, err := url.Parse(b.String())
+	if err != nil {
+		return nil, err
+	}
+	u.Host = strings.TrimSuffix(u.Host, ":")
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme %q is not supported, use http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// urlComponentAt returns the component of the URL skeleton s at byte offset i
+func urlComponentAt(s string, i int, authorityEnd int, hostStart int) urlComponent {
+	switch {
+	case i < hostStart:
+		return urlUserinfo
+	case i < authorityEnd:
+		// A colon outside of the brackets of an IPv6 address starts the port
+		host := s[hostStart:i]
+		if j := strings.LastIndex(host, "]"); j >= 0 {
+			host = host[j+1:]
+		} else if strings.Contains(host, "[") {
+			return urlHost
+		}
+		if strings.Contains(host, ":") {
+			return urlPort
+		}
+		return urlHost
+	}
+	rest := s[authorityEnd:i]
+	switch {
+	case strings.Contains(rest, "#"):
+		return urlFragment
+	case strings.Contains(rest, "?"):
+		return urlQuery
+	default:
+		return urlPath
+	}
+}
+
+// encodeURLValue encodes a value received from Safeguard for the component of the URL. bracketed tells whether
+// the configuration already encloses the value in brackets, like https://[{Target.AssetNetworkAddress}]/
+func encodeURLValue(val string, component urlComponent, bracketed bool) (string, error) {
+	switch component {
+	case urlUserinfo:
+		return url.User(val).String(), nil
+	case urlHost:
+		if strings.ContainsAny(val, "/?#@ \t") {
+			return "", fmt.Errorf("%q is not a valid host", val)
+		}
+		addr, zone, _ := strings.Cut(val, "%")
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			// IPv6 address, the zone of link-local addresses is escaped
+			if zone != "" {
+				addr += "%25" + zone
+			}
+			if !bracketed {
+				addr = "[" + addr + "]"
+			}
+			return addr, nil
+		}
+		return val, nil
+	case urlPort:
+		for _, c := range val {
+			if c < '0' || c > '9' {
+				return "", fmt.Errorf("%q is not a valid port", val)
+			}
+		}
+		return val, nil
+	case urlPath:
+		segments := strings.Split(val, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return strings.Join(segments, "/"), nil
+	case urlQuery:
+		return url.QueryEscape(val), nil
+	default:
+		return url.PathEscape(val), nil
+	}
+}
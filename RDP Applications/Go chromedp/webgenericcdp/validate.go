@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+const validateUsage = `Usage: webgenericcdp validate [-debug] <config-file> [<sample-stdin-json-file>]
+
+Loads the configuration file, builds the chromedp taskList from it and from a sample of the
+JSON received from OI-SG-RemoteApp-Launcher on STDIN, and prints the resolved plan with secrets masked.
+The browser is never launched. If the sample file is not given, the sample is read from STDIN.
+The exit code is 0 if no issues were found, 1 otherwise.`
+
+// runValidate implements the validate subcommand and returns the exit code
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, validateUsage) }
+	debug := flags.Bool("debug", false, "write debug logs to STDERR")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return 1
+	}
+
+	var logLevel = new(slog.LevelVar)
+	logLevel.Set(slog.LevelWarn)
+	if *debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+	slog.SetDefault(slog.New(newRedactingHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}), secrets)))
+	uuid := "validate"
+
+	configFile := flags.Arg(0)
+	issues := 0
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		for _, cerr := range splitErrors(err) {
+			fmt.Println("ERROR " + cerr.Error())
+			issues++
+		}
+	} else {
+		fmt.Println("Configuration: " + configFile + " OK")
+	}
+
+	var sample []byte
+	sampleName := "STDIN"
+	if flags.NArg() == 2 {
+		sampleName = flags.Arg(1)
+		sample, err = os.ReadFile(sampleName)
+	} else {
+		sample, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Println("ERROR cannot read sample payload from " + sampleName + ": " + err.Error())
+		return 1
+	}
+	payload, err := decodeLauncherPayload(sample)
+	if err != nil {
+		for _, perr := range splitErrors(err) {
+			fmt.Println("ERROR sample payload from " + sampleName + ": " + perr.Error())
+		}
+		return 1
+	}
+	launcherStdin := payload.values()
+	if payload.CliArgs != "" {
+		if _, err := parseCliArgs(payload.CliArgs); err != nil {
+			fmt.Println("ERROR sample payload from " + sampleName + ": " + err.Error())
+			issues++
+		}
+	}
+	fmt.Println("Sample payload: " + sampleName + " OK")
+	secrets.addFromStdin(launcherStdin, config.sensitiveKeys)
+
+	if issues > 0 {
+		// The plan built from an invalid configuration would be misleading
+		fmt.Println("Found " + strconv.Itoa(issues) + " issue(s)")
+		return 1
+	}
+
+	plan, err := buildTaskList(config, launcherStdin, uuid)
+	if err != nil {
+		for _, terr := range splitErrors(err) {
+			fmt.Println("ERROR " + terr.Error())
+			issues++
+		}
+	}
+
+	fmt.Println("Plan:")
+	for i, step := range plan.steps {
+		fmt.Printf("%4d. %s\n", i+1, secrets.redact(step))
+	}
+
+	if issues > 0 {
+		fmt.Println("Found " + strconv.Itoa(issues) + " issue(s)")
+		return 1
+	}
+	fmt.Println("No issues found")
+	return 0
+}
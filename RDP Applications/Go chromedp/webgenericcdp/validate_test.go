@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runValidateOutput runs the validate subcommand and returns its exit code and what it printed
+func runValidateOutput(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	defer slog.SetDefault(slog.Default())
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	code := runValidate(args)
+	w.Close()
+	return code, <-out
+}
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sample := write("sample.json", `{"username":"jdoe","password":"Val1dat3-Secret","cli_args":"app.conf -debug","Target.Custom":{"pin":"4711"}}`)
+
+	for _, tc := range []struct {
+		name   string
+		config string
+		sample string // Path of the sample, sample.json if empty
+		code   int
+		lines  []string // Printed lines, in this order
+		absent []string // Text which must not be printed
+	}{
+		{
+			name:   "valid",
+			config: "url=https://app.example.com/{username}\nloginActions=v::#user::{username}||s::#pw::password||v::#pin::{password}-{Target.Custom.pin}||c::#login",
+			lines: []string{
+				"Configuration: ",
+				"Sample payload: " + sample + " OK",
+				"Plan:",
+				"Navigate to https://app.example.com/jdoe",
+				"Enter secret into #pw: <hidden>",
+				"Enter value into #pin: <hidden>-4711",
+				"No issues found",
+			},
+			absent: []string{"Val1dat3-Secret"},
+		},
+		{
+			name:   "waits of the blocks",
+			config: "url=https://app.example.com\nactionTimeout=30s\nloginActions=first||case::#a||c::#a||end||first(within=5s)||case::#b||c::#b||end||if::#c||c::#c||end",
+			lines: []string{
+				"Wait up to 30s for the first element to appear:",
+				"Wait for the first element to appear within 5s (timeout 30s):",
+				"If #c appears within 5s (timeout 30s):",
+				"No issues found",
+			},
+		},
+		{
+			name:   "waits of the blocks without timeout",
+			config: "url=https://app.example.com\nactionTimeout=0\nloginActions=first||case::#a||c::#a||end||first(within=5s)||case::#b||c::#b||end",
+			lines: []string{
+				"Wait for the first element to appear without time limit:",
+				"Wait for the first element to appear within 5s:",
+				"No issues found",
+			},
+		},
+		{
+			name:   "configuration errors",
+			config: "url=https://app.example.com\nloginActions=c::#a||x::#b\nbrowser=firefox",
+			code:   1,
+			lines: []string{
+				"ERROR " + filepath.Join(dir, "app.conf") + `:2:21: invalid loginActions: unknown action "x"`,
+				"ERROR " + filepath.Join(dir, "app.conf") + `:3:9: invalid value for browser: "firefox" is not supported`,
+				"Sample payload: ",
+				"Found 2 issue(s)",
+			},
+			absent: []string{"Plan:"},
+		},
+		{
+			name:   "missing value of the sample",
+			config: "url=https://app.example.com\nloginActions=v::#user::{Target.AccountName}",
+			code:   1,
+			lines:  []string{"Configuration: ", "Sample payload: ", "ERROR ", "Target.AccountName", "Plan:", "Found 1 issue(s)"},
+		},
+		{
+			name:   "invalid sample",
+			config: "url=https://app.example.com\nloginActions=c::#login",
+			sample: write("invalid.json", `{"username":1}`),
+			code:   1,
+			lines:  []string{"Configuration: ", `ERROR sample payload from ` + filepath.Join(dir, "invalid.json") + `: payload field "username" must be a string, got number`},
+			absent: []string{"Plan:"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := write("app.conf", tc.config)
+			if tc.sample == "" {
+				tc.sample = sample
+			}
+			code, out := runValidateOutput(t, config, tc.sample)
+			if code != tc.code {
+				t.Errorf("exit code %d, want %d", code, tc.code)
+			}
+			rest := out
+			for _, line := range tc.lines {
+				i := strings.Index(rest, line)
+				if i < 0 {
+					t.Errorf("%q not printed in this order:\n%s", line, out)
+					break
+				}
+				rest = rest[i+len(line):]
+			}
+			for _, text := range tc.absent {
+				if strings.Contains(out, text) {
+					t.Errorf("%q printed:\n%s", text, out)
+				}
+			}
+		})
+	}
+
+	if code, _ := runValidateOutput(t); code != 1 {
+		t.Errorf("without configuration: exit code %d", code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+// Lookup of the values received from Safeguard via STDIN.
+//
+// Keys of the payload are flat and contain dots, like Target.AssetNetworkAddress, while some values are
+// objects, arrays or JSON text, like Target.TotpCodes. A path is looked up by the longest key matching its
+// beginning, the rest of the path descends into the value:
+//
+//	path    = segment { "." segment | "[" index "]" }
+//	segment = name { "[" index "]" }
+//
+// e.g. {Target.TotpCodes[0].Code} or {Target.Custom.tenantId}. Strings containing a JSON object or array are decoded when descended into.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is a name or an array index of a value path
+type pathSegment struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// parseValuePath splits a path like Target.TotpCodes[0].Code into its segments
+func parseValuePath(path string) ([]pathSegment, error) {
+	var segs []pathSegment
+	for _, field := range strings.Split(path, ".") {
+		name, rest, indexed := strings.Cut(field, "[")
+		if name == "" && (len(segs) == 0 || rest == "") {
+			return nil, fmt.Errorf("empty name in value path %q", path)
+		}
+		if indexed && rest == "" {
+			return nil, fmt.Errorf("invalid index [ in value path %q, expected a non-negative number like [0]", path)
+		}
+		if name != "" {
+			segs = append(segs, pathSegment{name: name})
+		}
+		for rest != "" {
+			index, after, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(index)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid index [%s in value path %q, expected a non-negative number like [0]", rest, path)
+			}
+			segs = append(segs, pathSegment{index: n, isIndex: true})
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("unexpected %q after ] in value path %q", after, path)
+			}
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return segs, nil
+}
+
+// lookupError tells which value of the configuration was not found and why
+type lookupError struct {
+	path     string
+	detail   string   // Empty if the key was not received at all
+	received []string // Keys of the payload if the key was not received, never the values
+}
+
+func (e *lookupError) Error() string {
+	if e.detail == "" {
+		return e.path
+	}
+	return e.path + " (" + e.detail + ")"
+}
+
+var errNotReceived = errors.New("not received")
+
+// lookupValue returns the value of path. A key received as it is, even with brackets, takes precedence over the path syntax.
+func lookupValue(launcherStdin map[string]interface{}, path string) (interface{}, *lookupError) {
+	if v, ok := launcherStdin[path]; ok {
+		if v == nil {
+			return nil, &lookupError{path: path, detail: "null"}
+		}
+		return v, nil
+	}
+	segs, perr := parseValuePath(path)
+	if perr != nil {
+		return nil, &lookupError{path: path, detail: perr.Error()}
+	}
+	v, err := descendValue(launcherStdin, segs, "")
+	if err == errNotReceived {
+		return nil, &lookupError{path: path, received: objectKeys(launcherStdin)}
+	}
+	if err != nil {
+		return nil, &lookupError{path: path, detail: err.Error()}
+	}
+	return v, nil
+}
+
+// descendValue looks up the segments within v, at is the part of the path already looked up
+func descendValue(v interface{}, segs []pathSegment, at string) (interface{}, error) {
+	if len(segs) == 0 {
+		if v == nil {
+			return nil, fmt.Errorf("%s is null", at)
+		}
+		return v, nil
+	}
+	v = decodeJSONText(v)
+	seg := segs[0]
+	if seg.isIndex {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is %s, not an array", at, withArticle(jsonType(v)))
+		}
+		if seg.index >= len(arr) {
+			return nil, fmt.Errorf("index %d is out of range, %s has %d element(s)", seg.index, at, len(arr))
+		}
+		return descendValue(arr[seg.index], segs[1:], fmt.Sprintf("%s[%d]", at, seg.index))
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is %s, not an object", at, withArticle(jsonType(v)))
+	}
+	// The longest key made of the names up to the next index
+	names := 0
+	for names < len(segs) && !segs[names].isIndex {
+		names++
+	}
+	for n := names; n > 0; n-- {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = segs[i].name
+		}
+		key := strings.Join(parts, ".")
+		if val, ok := obj[key]; ok {
+			if at != "" {
+				key = at + "." + key
+			}
+			return descendValue(val, segs[n:], key)
+		}
+	}
+	if at == "" {
+		return nil, errNotReceived
+	}
+	return nil, fmt.Errorf("%s has no key %q, available keys: %s", at, segs[0].name, strings.Join(objectKeys(obj), ", "))
+}
+
+// decodeJSONText decodes a string containing a JSON object or array, other values are returned unchanged
+func decodeJSONText(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return v
+	}
+	var decoded interface{}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil || dec.More() {
+		return v
+	}
+	return decoded
+}
+
+func objectKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func withArticle(typeName string) string {
+	if strings.ContainsAny(typeName[:1], "ao") {
+		return "an " + typeName
+	}
+	return "a " + typeName
+}
+
+var integerPattern = regexp.MustCompile(`^-?[0-9]+$`)
+
+// formatValue returns the text of a value as it is entered: strings as they are, numbers as written
+// (integers without exponent), booleans as true or false, objects and arrays as JSON
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return formatNumber(v.String())
+	case float64:
+		return formatNumber(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return fmt.Sprint(v)
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+}
+
+// formatNumber writes integral numbers, like 1e+06 or 1.0, without exponent and fraction
+func formatNumber(n string) string {
+	if integerPattern.MatchString(n) {
+		return n
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1e21 {
+		return n
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// stdinValue returns the text of the value of path received from Safeguard via STDIN
+func stdinValue(launcherStdin map[string]interface{}, path string) (string, error) {
+	v, err := lookupValue(launcherStdin, path)
+	if err != nil {
+		return "", missingValuesError([]*lookupError{err})
+	}
+	return formatValue(v), nil
+}
+
+// missingValuesError lists the values which were not found, and the keys which were received instead.
+// Secrets are never part of the message, only the names of the values.
+func missingValuesError(errs []*lookupError) error {
+	paths := make([]string, len(errs))
+	var received []string
+	for i, e := range errs {
+		paths[i] = e.Error()
+		if e.received != nil {
+			received = e.received
+		}
+	}
+	msg := "value(s) referenced by the configuration not received from Safeguard on STDIN: " + strings.Join(paths, ", ")
+	if received != nil {
+		msg += "; received keys: " + strings.Join(received, ", ")
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseValuePath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		segs []pathSegment
+		err  string
+	}{
+		{path: "username", segs: []pathSegment{{name: "username"}}},
+		{path: "Target.TotpCodes[0].Code", segs: []pathSegment{{name: "Target"}, {name: "TotpCodes"}, {index: 0, isIndex: true}, {name: "Code"}}},
+		{path: "a[1][2]", segs: []pathSegment{{name: "a"}, {index: 1, isIndex: true}, {index: 2, isIndex: true}}},
+		{path: "a.[3]", segs: []pathSegment{{name: "a"}, {index: 3, isIndex: true}}},
+		{path: "", err: `empty name in value path ""`},
+		{path: "a..b", err: `empty name in value path "a..b"`},
+		{path: "a[", err: `invalid index [ in value path "a["`},
+		{path: "a[x]", err: `invalid index [x] in value path "a[x]"`},
+		{path: "a[-1]", err: `invalid index [-1] in value path "a[-1]"`},
+		{path: "a[0", err: `invalid index [0 in value path "a[0"`},
+		{path: "a[0]b", err: `unexpected "b" after ] in value path "a[0]b"`},
+	} {
+		segs, err := parseValuePath(tc.path)
+		if tc.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("%q: error %v, want %q", tc.path, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(segs, tc.segs) {
+			t.Errorf("%q: %+v, %v", tc.path, segs, err)
+		}
+	}
+}
+
+func TestLookupValue(t *testing.T) {
+	stdin := map[string]interface{}{
+		"Target.TotpCodes": `[{"Code":"123456"}]`,
+		"Target.Custom":    map[string]interface{}{"tenant.id": "T1", "list": []interface{}{"a"}},
+		"Target.Null":      nil,
+		"odd[0]":           "as received",
+	}
+	for _, tc := range []struct {
+		path string
+		want interface{}
+		err  string
+	}{
+		{path: "Target.TotpCodes[0].Code", want: "123456"},
+		{path: "Target.Custom.tenant.id", want: "T1"},
+		{path: "Target.Custom.list[0]", want: "a"},
+		{path: "odd[0]", want: "as received"},
+		{path: "Target.Null", err: "Target.Null (null)"},
+		{path: "Target.Custom.list[1]", err: "index 1 is out of range"},
+		{path: "Target.Custom.tenant.id[0]", err: "not an array"},
+		{path: "Target.Missing", err: "Target.Missing"},
+	} {
+		v, err := lookupValue(stdin, tc.path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.path, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(v, tc.want) {
+			t.Errorf("%s: %v, %v", tc.path, v, err)
+		}
+	}
+}
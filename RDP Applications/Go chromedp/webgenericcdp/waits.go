@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+)
+
+// Poll interval of the wait actions which have no CDP event to wait for
+const waitPollInterval = 100 * time.Millisecond
+
+func isWaitAction(kind actionKind) bool {
+	switch kind {
+	case actionWaitVisible, actionWaitGone, actionWaitURL, actionWaitTitle, actionSleep:
+		return true
+	}
+	return false
+}
+
+// waitAction returns the task of a wait action. Unlike the other actions it does not wait for an element first.
+func waitAction(action loginAction, queryOption chromedp.QueryOption) chromedp.Action {
+	switch action.kind {
+	case actionWaitVisible:
+		sel := resolveSelector(action.selector, queryOption)
+		return chromedp.WaitVisible(sel.query, sel.option)
+	case actionWaitGone:
+		return waitGone(resolveSelector(action.selector, queryOption))
+	case actionWaitURL:
+		return waitMatch(action.pattern, func(s *string) chromedp.Action { return chromedp.Location(s) })
+	case actionWaitTitle:
+		return waitMatch(action.pattern, func(s *string) chromedp.Action { return chromedp.Title(s) })
+	default:
+		return chromedp.Sleep(action.sleep)
+	}
+}
+
+// describeWaitAction returns the description of a wait action for the plan
+func describeWaitAction(action loginAction) string {
+	switch action.kind {
+	case actionWaitVisible:
+		return "Wait until element is visible: " + action.selector
+	case actionWaitGone:
+		return "Wait until element is gone: " + action.selector
+	case actionWaitURL:
+		return "Wait until URL matches " + action.selector
+	case actionWaitTitle:
+		return "Wait until title matches " + action.selector
+	default:
+		return "Sleep " + action.sleep.String()
+	}
+}
+
+// waitGone waits until no element matches the selector or the matching element is no longer visible
+func waitGone(sel elementSelector) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for {
+			var nodes []*cdp.Node
+			if err := chromedp.Nodes(sel.query, &nodes, sel.option, chromedp.AtLeast(0)).Do(ctx); err != nil {
+				return err
+			}
+			if len(nodes) == 0 {
+				return nil
+			}
+			i, err := waitForFirstElement(ctx, []elementSelector{sel}, elementProbeTimeout)
+			if err != nil {
+				return err
+			}
+			if i < 0 {
+				return nil
+			}
+			if err := chromedp.Sleep(waitPollInterval).Do(ctx); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// waitMatch polls the string read by the task returned by read (like the URL or the title of the page) until it matches re
+func waitMatch(re *regexp.Regexp, read func(*string) chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for {
+			var s string
+			if err := read(&s).Do(ctx); err != nil {
+				return err
+			}
+			if re.MatchString(s) {
+				return nil
+			}
+			if err := chromedp.Sleep(waitPollInterval).Do(ctx); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Wait period of a single probe of waitForFirstElement
+const elementProbeTimeout = 100 * time.Millisecond
+
+// waitForFirstElement waits until one of the selectors becomes visible and returns its index.
+// It returns -1 if none of them became visible within wait. If wait is 0, it waits without limit.
+func waitForFirstElement(ctx context.Context, selectors []elementSelector, wait time.Duration) (int, error) {
+	start := time.Now()
+	for {
+		for i, sel := range selectors {
+			probe := elementProbeTimeout
+			if len(selectors) == 1 && wait > 0 {
+				probe = wait
+			}
+			pctx, cancel := context.WithTimeout(ctx, probe)
+			err := chromedp.WaitVisible(sel.query, sel.option).Do(pctx)
+			cancel()
+			switch {
+			case err == nil:
+				return i, nil
+			case ctx.Err() != nil:
+				return -1, ctx.Err()
+			case !errors.Is(err, context.DeadlineExceeded):
+				return -1, err
+			}
+		}
+		if wait > 0 && time.Since(start) >= wait {
+			return -1, nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestDescribeWaitAction(t *testing.T) {
+	actions, err := parseLoginActions("wait-visible::#a||wait-gone::xpath://div[@id='b']||wait-url::^https://app/||wait-title::^Done$||sleep::1500||c::#c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		"Wait until element is visible: #a",
+		"Wait until element is gone: xpath://div[@id='b']",
+		"Wait until URL matches ^https://app/",
+		"Wait until title matches ^Done$",
+		"Sleep 1.5s",
+	} {
+		if !isWaitAction(actions[i].kind) {
+			t.Errorf("%s is not a wait action", actions[i].kind)
+		}
+		if got := describeWaitAction(actions[i]); got != want {
+			t.Errorf("%s: %q, want %q", actions[i].kind, got, want)
+		}
+	}
+	if isWaitAction(actions[5].kind) {
+		t.Errorf("%s is a wait action", actions[5].kind)
+	}
+}
+
+// Page of TestWaits: after 300 ms the spinner is hidden, the late element is shown and the title is set
+const waitsTestPage = `<title>Loading</title><div id="spinner">...</div><div id="shown">shown</div><div id="late" hidden>late</div>
+<script>setTimeout(() => { spinner.hidden = true; late.hidden = false; document.title = "Done" }, 300)</script>`
+
+func TestWaits(t *testing.T) {
+	launch, _ := testBrowserLaunch(t)
+	allocCtx, allocator := newPipeAllocator(context.Background(), launch, "test")
+	ctx, _ := chromedp.NewContext(allocCtx, allocator)
+	defer chromedp.Cancel(ctx)
+	load := func(t *testing.T) {
+		t.Helper()
+		if err := chromedp.Run(ctx, chromedp.Navigate("data:text/html,"+url.PathEscape(waitsTestPage))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	selectors := func(s ...string) []elementSelector { return resolveSelectors(s, chromedp.ByQuery) }
+	// first runs waitForFirstElement within the browser context ctx
+	first := func(ctx context.Context, selectors []elementSelector, wait time.Duration) (i int, err error) {
+		err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			i, err = waitForFirstElement(ctx, selectors, wait)
+			return err
+		}))
+		return i, err
+	}
+
+	t.Run("first element", func(t *testing.T) {
+		load(t)
+		if i, err := first(ctx, selectors("#missing", "#shown"), 0); i != 1 || err != nil {
+			t.Errorf("visible element: %d, %v", i, err)
+		}
+		start := time.Now()
+		if i, err := first(ctx, selectors("#missing"), 300*time.Millisecond); i != -1 || err != nil || time.Since(start) < 300*time.Millisecond {
+			t.Errorf("missing element: %d, %v after %s", i, err, time.Since(start))
+		}
+		// Without time limit, the selectors are probed until one becomes visible
+		if i, err := first(ctx, selectors("#missing", "#late"), 0); i != 1 || err != nil {
+			t.Errorf("late element: %d, %v", i, err)
+		}
+		cctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		if i, err := first(cctx, selectors("#missing"), 0); i != -1 || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("cancelled: %d, %v", i, err)
+		}
+	})
+
+	t.Run("wait actions", func(t *testing.T) {
+		actions, err := parseLoginActions("wait-gone::#spinner||wait-visible::id:late||wait-title::^Done$||wait-url::^data:text/html,||wait-gone::#missing||sleep::100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		load(t)
+		for _, action := range actions {
+			actx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err := chromedp.Run(actx, waitAction(action, chromedp.ByQuery))
+			cancel()
+			if err != nil {
+				t.Errorf("%s: %v", describeWaitAction(action), err)
+			}
+		}
+	})
+
+	t.Run("wait actions time out", func(t *testing.T) {
+		actions, err := parseLoginActions("wait-gone::#shown||wait-title::^Other$||wait-url::^https:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		load(t)
+		for _, action := range actions {
+			actx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			err := chromedp.Run(actx, waitAction(action, chromedp.ByQuery))
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s: %v", describeWaitAction(action), err)
+			}
+		}
+	})
+}